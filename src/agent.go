@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+const collectorDefaultPort = "7000"
+
+const agentTimeout = 5 * time.Second
+
+// agentResult is a single ping result as agents stream it to the collector,
+// one JSON object per line.
+type agentResult struct {
+	Agent      string    `json:"agent"`
+	Target     string    `json:"target"` // host and port as given to the agent
+	Address    string    `json:"address"`
+	Time       time.Time `json:"time"`
+	RTT        float64   `json:"rtt_ms"`
+	Error      string    `json:"error,omitempty"`
+	Unexpected bool      `json:"unexpected,omitempty"`
+}
+
+// agentStream sends the results of `tcping agent` to a collector. Results
+// are queued and sent in the background, so an unreachable collector does not
+// hold up the pings. The connection is opened for the first result and again
+// after it broke; results are dropped while the collector cannot be reached.
+type agentStream struct {
+	collector string
+	name      string
+	conn      net.Conn
+
+	queue chan agentResult
+	done  chan struct{}
+}
+
+func newAgentStream(collector, name string) (*agentStream, error) {
+	if _, _, err := net.SplitHostPort(collector); err != nil {
+		collector = net.JoinHostPort(strings.Trim(collector, "[]"), collectorDefaultPort)
+	}
+	if name == "" {
+		var err error
+		if name, err = os.Hostname(); err != nil {
+			return nil, fmt.Errorf("--agent-name is required, the host name is unknown: %v", err)
+		}
+	}
+	a := &agentStream{
+		collector: collector,
+		name:      name,
+		queue:     make(chan agentResult, 1000),
+		done:      make(chan struct{}),
+	}
+	go a.deliver()
+	return a, nil
+}
+
+// send queues the result of a single ping for the collector.
+func (a *agentStream) send(t *target, elapsed time.Duration, probeErr error, sentAt time.Time) {
+	r := agentResult{
+		Agent:   a.name,
+		Target:  targetName(t),
+		Address: t.String(),
+		Time:    sentAt,
+		RTT:     milliseconds(elapsed),
+	}
+	if probeErr != nil {
+		r.Error = probeErr.Error()
+		_, r.Unexpected = probeErr.(*mismatchError)
+	}
+	select {
+	case a.queue <- r:
+	default:
+		// The collector is too slow or unreachable; deliver reports the
+		// failures already.
+	}
+}
+
+// deliver writes the queued results to the collector one after the other.
+// Failures are reported once until a result gets through again.
+func (a *agentStream) deliver() {
+	defer close(a.done)
+	failing := false
+	for r := range a.queue {
+		err := a.write(r)
+		if err != nil && !failing {
+			fmt.Printf("Failed to send results to the collector: %v\n", err)
+		} else if err == nil && failing {
+			fmt.Printf("Sending results to the collector %s again\n", a.collector)
+		}
+		failing = err != nil
+	}
+	if a.conn != nil {
+		a.conn.Close()
+	}
+}
+
+func (a *agentStream) write(r agentResult) error {
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	if a.conn == nil {
+		if a.conn, err = net.DialTimeout("tcp", a.collector, agentTimeout); err != nil {
+			a.conn = nil
+			return err
+		}
+	}
+	a.conn.SetWriteDeadline(time.Now().Add(agentTimeout))
+	if _, err := a.conn.Write(append(line, '\n')); err != nil {
+		a.conn.Close()
+		a.conn = nil
+		return err
+	}
+	return nil
+}
+
+// close sends the results still queued and waits for them a little while.
+func (a *agentStream) close() {
+	close(a.queue)
+	select {
+	case <-a.done:
+	case <-time.After(2 * agentTimeout):
+		fmt.Println("Gave up waiting for results to be sent to the collector")
+	}
+}
+
+// targetName is how the collector groups the results of t, by the host as
+// given rather than the address, which may differ between the sites. The
+// two targets of --compare-family add their address family.
+func targetName(t *target) string {
+	name := t.host + ":" + t.port
+	if isIPv6(t.host) {
+		name = "[" + strings.Trim(t.host, "[]") + "]:" + t.port
+	}
+	if t.perFamily {
+		name += " (" + t.version + ")"
+	}
+	return name
+}
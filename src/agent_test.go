@@ -0,0 +1,22 @@
+package main
+
+import "testing"
+
+func TestTargetName(t *testing.T) {
+	tests := []struct {
+		t    *target
+		want string
+	}{
+		{&target{host: "example.com", address: "192.0.2.1", port: "80"}, "example.com:80"},
+		{&target{host: "192.0.2.1", address: "192.0.2.1", port: "80"}, "192.0.2.1:80"},
+		{&target{host: "2001:db8::1", address: "[2001:db8::1]", port: "443"}, "[2001:db8::1]:443"},
+		{&target{host: "[2001:db8::1]", address: "[2001:db8::1]", port: "443"}, "[2001:db8::1]:443"},
+		{&target{host: "example.com", version: "ipv4", perFamily: true, address: "192.0.2.1", port: "80"}, "example.com:80 (ipv4)"},
+		{&target{host: "example.com", version: "ipv6", perFamily: true, address: "[2001:db8::1]", port: "80"}, "example.com:80 (ipv6)"},
+	}
+	for _, tt := range tests {
+		if got := targetName(tt.t); got != tt.want {
+			t.Errorf("targetName(%+v) = %q, want %q", *tt.t, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// aggregate is the summary of all pings sent to one target during one
+// --aggregate period.
+type aggregate struct {
+	Start     time.Time `json:"start"`
+	Sent      int       `json:"sent"`
+	Responded int       `json:"responded"`
+	Loss      float64   `json:"loss"`
+	Min       float64   `json:"min_ms"`
+	Avg       float64   `json:"avg_ms"`
+	Max       float64   `json:"max_ms"`
+}
+
+// maxKeptBuckets is a week of one minute buckets.
+const maxKeptBuckets = 7 * 24 * 60
+
+// aggregator groups ping results into fixed time buckets per target, printing
+// each bucket when it closes and optionally appending it to a CSV file. The
+// last maxKeptBuckets summaries of every target are kept for --summary-file
+// and --state-file; the CSV file has all of them.
+type aggregator struct {
+	period time.Duration
+	file   *os.File
+	csv    *csv.Writer
+
+	// show prints the summary of a bucket. main sends it through the same
+	// output as the ping results.
+	show func(t *target, line string)
+}
+
+func newAggregator(period time.Duration, path string) (*aggregator, error) {
+	if period <= 0 {
+		return nil, fmt.Errorf("--aggregate must be a positive duration")
+	}
+	a := &aggregator{period: period, show: func(t *target, line string) { fmt.Println(line) }}
+	if path == "" {
+		return a, nil
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	a.file = file
+	a.csv = csv.NewWriter(file)
+	if info, err := file.Stat(); err == nil && info.Size() == 0 {
+		a.csv.Write([]string{"start", "target", "sent", "responded", "loss", "min_ms", "avg_ms", "max_ms"})
+		a.csv.Flush()
+	}
+	return a, nil
+}
+
+// add records a ping result, closing the target's current bucket first if
+// its period has passed.
+func (a *aggregator) add(t *target, elapsed time.Duration, err error) {
+	now := time.Now()
+	if !t.bucketStart.IsZero() && now.Sub(t.bucketStart) >= a.period {
+		a.closeBucket(t)
+	}
+	if t.bucketStart.IsZero() {
+		t.bucketStart = now.Truncate(a.period)
+	}
+	t.bucket.add(elapsed, err)
+}
+
+// flush closes the partial buckets of all targets at the end of a run.
+func (a *aggregator) flush(targets []*target) {
+	for _, t := range targets {
+		if !t.bucketStart.IsZero() {
+			a.closeBucket(t)
+		}
+	}
+	if a.file != nil {
+		a.file.Close()
+	}
+}
+
+func (a *aggregator) closeBucket(t *target) {
+	s := t.bucket
+	agg := aggregate{
+		Start:     t.bucketStart,
+		Sent:      s.sentCount,
+		Responded: s.respondedCount,
+		Loss:      s.loss(),
+	}
+	if s.respondedCount > 0 {
+		agg.Min = milliseconds(s.minTime)
+		agg.Avg = milliseconds(s.avg())
+		agg.Max = milliseconds(s.maxTime)
+	}
+	t.buckets = append(t.buckets, agg)
+	if len(t.buckets) > maxKeptBuckets {
+		t.buckets = append(t.buckets[:0], t.buckets[len(t.buckets)-maxKeptBuckets:]...)
+	}
+	t.bucket = statistics{}
+	t.bucketStart = time.Time{}
+
+	if agg.Responded > 0 {
+		a.show(t, fmt.Sprintf("[%s] %s: %d sent, %.2f%% loss, min/avg/max = %s/%s/%s",
+			agg.Start.Format("15:04:05"), t, agg.Sent, agg.Loss, formatRTT(s.minTime), formatRTT(s.avg()), formatRTT(s.maxTime)))
+	} else {
+		a.show(t, fmt.Sprintf("[%s] %s: %d sent, %.2f%% loss", agg.Start.Format("15:04:05"), t, agg.Sent, agg.Loss))
+	}
+
+	if a.csv != nil {
+		a.csv.Write([]string{
+			agg.Start.Format(time.RFC3339),
+			t.String(),
+			strconv.Itoa(agg.Sent),
+			strconv.Itoa(agg.Responded),
+			strconv.FormatFloat(agg.Loss, 'f', 2, 64),
+			strconv.FormatFloat(agg.Min, 'f', 3, 64),
+			strconv.FormatFloat(agg.Avg, 'f', 3, 64),
+			strconv.FormatFloat(agg.Max, 'f', 3, 64),
+		})
+		a.csv.Flush()
+		if err := a.csv.Error(); err != nil {
+			fmt.Printf("Failed to write aggregate: %v\n", err)
+		}
+	}
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const alertTimeout = 10 * time.Second
+
+// notifier delivers an alert to one destination.
+type notifier interface {
+	name() string
+	notify(subject, body string) error
+}
+
+// alertOptions are the command line options of the notifiers.
+type alertOptions struct {
+	slackWebhook string
+	telegram     string // token:chat
+	smtp         string // smtp[s]://[user:password@]host[:port]
+	mailFrom     string
+	mailTo       string
+	rtt          time.Duration
+	interval     time.Duration
+}
+
+// alerter watches the ping results of every target and notifies when a
+// target goes down, comes back up, or its RTT crosses --alert-rtt. Down and
+// up follow the outages of the target, so --down-after and --up-after apply. Alerts
+// for a target are sent at most once per interval; changes in between are
+// folded into the next alert, which always reports the current state.
+type alerter struct {
+	notifiers []notifier
+	rtt       time.Duration
+	interval  time.Duration
+
+	states map[*target]*alertState
+	queue  chan alert
+	done   chan struct{}
+}
+
+type alertState struct {
+	status   string // "up", "down" or "slow"
+	notified string // the status last alerted about
+	since    time.Time
+	lastSent time.Time
+	changes  int // since the last alert
+	lastErr  error
+	lastRTT  time.Duration
+}
+
+type alert struct {
+	subject, body string
+}
+
+func newAlerter(opts alertOptions) (*alerter, error) {
+	a := &alerter{
+		rtt:      opts.rtt,
+		interval: opts.interval,
+		states:   make(map[*target]*alertState),
+		queue:    make(chan alert, 100),
+		done:     make(chan struct{}),
+	}
+	if opts.slackWebhook != "" {
+		a.notifiers = append(a.notifiers, &slackNotifier{webhook: opts.slackWebhook})
+	}
+	if opts.telegram != "" {
+		// Bot tokens contain a colon themselves, the chat is after the last.
+		i := strings.LastIndex(opts.telegram, ":")
+		if i <= 0 || i == len(opts.telegram)-1 {
+			return nil, fmt.Errorf("--telegram must be token:chat, e.g. 123456:ABC-DEF:-1001234567")
+		}
+		a.notifiers = append(a.notifiers, &telegramNotifier{token: opts.telegram[:i], chat: opts.telegram[i+1:]})
+	}
+	if opts.smtp != "" {
+		n, err := newSMTPNotifier(opts.smtp, opts.mailFrom, opts.mailTo)
+		if err != nil {
+			return nil, err
+		}
+		a.notifiers = append(a.notifiers, n)
+	} else if opts.mailFrom != "" || opts.mailTo != "" {
+		return nil, fmt.Errorf("--mail-from and --mail-to require --smtp")
+	}
+	if len(a.notifiers) == 0 {
+		if opts.rtt != 0 {
+			return nil, fmt.Errorf("--alert-rtt requires --slack-webhook, --telegram or --smtp")
+		}
+		return nil, nil
+	}
+	if opts.interval < 0 || opts.rtt < 0 {
+		return nil, fmt.Errorf("--alert-interval and --alert-rtt cannot be negative")
+	}
+
+	go a.deliver()
+	return a, nil
+}
+
+// record accounts a ping result and queues an alert if the state of the
+// target changed and its last alert is long enough ago.
+func (a *alerter) record(t *target, elapsed time.Duration, err error, at time.Time) {
+	s := a.states[t]
+	if s == nil {
+		s = &alertState{status: "up", notified: "up", since: at}
+		a.states[t] = s
+	}
+
+	status, since := s.status, at
+	switch down, start := t.outages.down(); {
+	case down:
+		status, since = "down", start
+	case err != nil:
+		// A failed ping that does not make an outage yet leaves the
+		// state as it is.
+	case a.rtt > 0 && elapsed > a.rtt:
+		status = "slow"
+	default:
+		status = "up"
+	}
+	if status != s.status {
+		// Up again since the end of the outage, unless the statistics were
+		// reset in between.
+		if o := t.outages.outages; s.status == "down" && len(o) > 0 {
+			since = o[len(o)-1].End
+		}
+		s.status, s.since = status, since
+		s.changes++
+	}
+	if err != nil {
+		s.lastErr = err
+	} else {
+		s.lastRTT = elapsed
+	}
+
+	if s.status != s.notified && at.Sub(s.lastSent) >= a.interval {
+		a.send(t, s, at)
+	}
+}
+
+// reset forgets the state of t when its statistics are reset.
+func (a *alerter) reset(t *target) {
+	delete(a.states, t)
+}
+
+// send queues the alert for the current state of t.
+func (a *alerter) send(t *target, s *alertState, at time.Time) {
+	subject, body := a.message(t, s)
+	if s.changes > 1 {
+		body += fmt.Sprintf(" (%d changes in between were not alerted, --alert-interval)", s.changes-1)
+	}
+	s.notified, s.lastSent, s.changes = s.status, at, 0
+
+	select {
+	case a.queue <- alert{subject, body}:
+	default:
+		fmt.Printf("Alert for %s dropped, too many alerts pending\n", t)
+	}
+}
+
+func (a *alerter) message(t *target, s *alertState) (string, string) {
+	at := s.since.Format("2006-01-02 15:04:05")
+	switch {
+	case s.status == "down":
+		return fmt.Sprintf("tcping: %s is down", t), fmt.Sprintf("%s is down since %s: %v", t, at, s.lastErr)
+	case s.status == "slow":
+		return fmt.Sprintf("tcping: %s is slow", t), fmt.Sprintf("%s answers in %s since %s, above %s", t, formatRTT(s.lastRTT), at, formatRTT(a.rtt))
+	case s.notified == "slow":
+		return fmt.Sprintf("tcping: %s is fast again", t), fmt.Sprintf("%s answers in %s again since %s", t, formatRTT(s.lastRTT), at)
+	}
+	return fmt.Sprintf("tcping: %s is up", t), fmt.Sprintf("%s is up again since %s, RTT %s", t, at, formatRTT(s.lastRTT))
+}
+
+// deliver sends the queued alerts one after the other, so a slow notifier
+// does not hold up the pings.
+func (a *alerter) deliver() {
+	defer close(a.done)
+	for al := range a.queue {
+		for _, n := range a.notifiers {
+			if err := n.notify(al.subject, al.body); err != nil {
+				fmt.Printf("Failed to send alert via %s: %v\n", n.name(), err)
+			}
+		}
+	}
+}
+
+// close sends the alerts held back by the interval, so the last state of
+// every target is reported, and waits for the queued alerts to be sent.
+func (a *alerter) close(targets []*target) {
+	for _, t := range targets {
+		if s := a.states[t]; s != nil && s.status != s.notified {
+			a.send(t, s, time.Now())
+		}
+	}
+	close(a.queue)
+	select {
+	case <-a.done:
+	case <-time.After(2 * alertTimeout):
+		fmt.Println("Gave up waiting for alerts to be sent")
+	}
+}
+
+var alertClient = &http.Client{Timeout: alertTimeout}
+
+func postJSON(url string, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	resp, err := alertClient.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("HTTP %s", resp.Status)
+	}
+	return nil
+}
+
+// slackNotifier posts to a Slack incoming webhook.
+type slackNotifier struct {
+	webhook string
+}
+
+func (n *slackNotifier) name() string { return "Slack" }
+
+func (n *slackNotifier) notify(subject, body string) error {
+	return postJSON(n.webhook, map[string]string{"text": "*" + subject + "*\n" + body})
+}
+
+// telegramNotifier sends a message from a bot to a Telegram chat.
+type telegramNotifier struct {
+	token string
+	chat  string
+}
+
+func (n *telegramNotifier) name() string { return "Telegram" }
+
+func (n *telegramNotifier) notify(subject, body string) error {
+	err := postJSON("https://api.telegram.org/bot"+n.token+"/sendMessage", map[string]string{
+		"chat_id": n.chat,
+		"text":    subject + "\n" + body,
+	})
+	if err != nil {
+		// The URL carries the token, keep it out of the output.
+		return fmt.Errorf("%s", strings.Replace(err.Error(), n.token, "<token>", -1))
+	}
+	return nil
+}
+
+// smtpNotifier mails alerts. smtp:// URLs use STARTTLS when the server
+// offers it, smtps:// URLs connect with TLS right away.
+type smtpNotifier struct {
+	address  string
+	host     string
+	implicit bool
+	auth     smtp.Auth
+	from     string
+	to       []string
+}
+
+func newSMTPNotifier(server, from, to string) (*smtpNotifier, error) {
+	u, err := url.Parse(server)
+	if err != nil || (u.Scheme != "smtp" && u.Scheme != "smtps") || u.Hostname() == "" {
+		return nil, fmt.Errorf("--smtp must be smtp://[user:password@]host[:port] or smtps://...")
+	}
+	if to == "" {
+		return nil, fmt.Errorf("--smtp requires --mail-to")
+	}
+
+	n := &smtpNotifier{host: u.Hostname(), implicit: u.Scheme == "smtps", from: from}
+	port := u.Port()
+	if port == "" {
+		port = "25"
+		if n.implicit {
+			port = "465"
+		}
+	}
+	n.address = net.JoinHostPort(n.host, port)
+	if u.User != nil {
+		password, _ := u.User.Password()
+		n.auth = smtp.PlainAuth("", u.User.Username(), password, n.host)
+	}
+	for _, addr := range strings.Split(to, ",") {
+		n.to = append(n.to, strings.TrimSpace(addr))
+	}
+	if n.from == "" {
+		hostname, _ := os.Hostname()
+		n.from = "tcping@" + hostname
+	}
+	return n, nil
+}
+
+func (n *smtpNotifier) name() string { return "SMTP" }
+
+func (n *smtpNotifier) notify(subject, body string) error {
+	msg := []byte("From: " + n.from + "\r\n" +
+		"To: " + strings.Join(n.to, ", ") + "\r\n" +
+		"Subject: " + subject + "\r\n" +
+		"Date: " + time.Now().Format(time.RFC1123Z) + "\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"\r\n" + body + "\r\n")
+
+	dialer := &net.Dialer{Timeout: alertTimeout}
+	var conn net.Conn
+	var err error
+	if n.implicit {
+		conn, err = tls.DialWithDialer(dialer, "tcp", n.address, &tls.Config{ServerName: n.host})
+	} else {
+		conn, err = dialer.Dial("tcp", n.address)
+	}
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(alertTimeout))
+	c, err := smtp.NewClient(conn, n.host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok && !n.implicit {
+		if err := c.StartTLS(&tls.Config{ServerName: n.host}); err != nil {
+			return err
+		}
+	}
+	if n.auth != nil {
+		if err := c.Auth(n.auth); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(n.from); err != nil {
+		return err
+	}
+	for _, addr := range n.to {
+		if err := c.Rcpt(addr); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
@@ -0,0 +1,229 @@
+//go:build linux
+
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// ICMPv6 neighbor discovery message and option types.
+const (
+	ndpNeighborSolicitation  = 135
+	ndpNeighborAdvertisement = 136
+	ndpSourceLinkAddress     = 1
+	ndpTargetLinkAddress     = 2
+)
+
+// newARPProbe returns a probe that resolves the link-layer address of the
+// target with an ARP request, or an IPv6 neighbor solicitation, before every
+// ping and shows the answer next to the result of probe. The targets must be
+// on a local subnet. ARP needs root or CAP_NET_RAW, which is checked here.
+func newARPProbe(targets []*target, probe probeFunc) (probeFunc, error) {
+	fd, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_DGRAM, int(htons(syscall.ETH_P_ARP)))
+	if err != nil {
+		return nil, fmt.Errorf("--arp needs root or CAP_NET_RAW: %v", err)
+	}
+	syscall.Close(fd)
+	for _, t := range targets {
+		if _, _, err := onLinkInterface(net.ParseIP(strings.Trim(t.address, "[]"))); err != nil {
+			return nil, fmt.Errorf("--arp: %v", err)
+		}
+	}
+
+	return func(t *target, timeout time.Duration) (time.Duration, string, error) {
+		mac, kind, elapsed, err := resolveNeighbor(net.ParseIP(strings.Trim(t.address, "[]")), timeout)
+		if err != nil {
+			return elapsed, "", err
+		}
+		neighbor := fmt.Sprintf("%s reply in %s from %s", kind, formatRTT(elapsed), mac)
+
+		elapsed, detail, err := probe(t, timeout)
+		if detail != "" {
+			neighbor += ", " + detail
+		}
+		return elapsed, neighbor, err
+	}, nil
+}
+
+// resolveNeighbor asks for the link-layer address of ip on the interface of
+// its subnet and returns it with the kind of request used.
+func resolveNeighbor(ip net.IP, timeout time.Duration) (net.HardwareAddr, string, time.Duration, error) {
+	iface, local, err := onLinkInterface(ip)
+	if err != nil {
+		return nil, "", 0, err
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		mac, elapsed, err := arpRequest(iface, local.To4(), ip4, timeout)
+		return mac, "ARP", elapsed, err
+	}
+	mac, elapsed, err := neighborSolicitation(iface, ip, timeout)
+	return mac, "NDP", elapsed, err
+}
+
+// onLinkInterface returns the interface whose subnet contains ip and the
+// local address on that subnet. Interfaces without an Ethernet address, such
+// as the loopback, do not resolve neighbors and are skipped.
+func onLinkInterface(ip net.IP) (*net.Interface, net.IP, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, nil, err
+	}
+	for i := range ifaces {
+		iface := &ifaces[i]
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 || len(iface.HardwareAddr) != 6 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if network, ok := addr.(*net.IPNet); ok && network.Contains(ip) {
+				return iface, network.IP, nil
+			}
+		}
+	}
+	return nil, nil, fmt.Errorf("%s is not on a local subnet", ip)
+}
+
+// arpRequest broadcasts an ARP request for dst from src on iface and waits
+// for the reply.
+func arpRequest(iface *net.Interface, src, dst net.IP, timeout time.Duration) (net.HardwareAddr, time.Duration, error) {
+	fd, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_DGRAM, int(htons(syscall.ETH_P_ARP)))
+	if err != nil {
+		return nil, 0, err
+	}
+	defer syscall.Close(fd)
+	if err := syscall.Bind(fd, &syscall.SockaddrLinklayer{Protocol: htons(syscall.ETH_P_ARP), Ifindex: iface.Index}); err != nil {
+		return nil, 0, err
+	}
+
+	packet := make([]byte, 28)
+	binary.BigEndian.PutUint16(packet[0:2], syscall.ARPHRD_ETHER)
+	binary.BigEndian.PutUint16(packet[2:4], syscall.ETH_P_IP)
+	packet[4], packet[5] = 6, 4 // address lengths
+	binary.BigEndian.PutUint16(packet[6:8], 1)
+	copy(packet[8:14], iface.HardwareAddr)
+	copy(packet[14:18], src)
+	copy(packet[24:28], dst)
+
+	broadcast := &syscall.SockaddrLinklayer{Protocol: htons(syscall.ETH_P_ARP), Ifindex: iface.Index, Halen: 6}
+	copy(broadcast.Addr[:], []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+
+	start := time.Now()
+	if err := syscall.Sendto(fd, packet, 0, broadcast); err != nil {
+		return nil, 0, err
+	}
+	buf := make([]byte, 1500)
+	for {
+		n, err := recvUntil(fd, buf, start, timeout)
+		elapsed := time.Since(start)
+		if err == syscall.EAGAIN {
+			return nil, elapsed, neighborTimeout("no ARP reply received: i/o timeout")
+		} else if err != nil {
+			return nil, elapsed, err
+		}
+		// Only a reply (operation 2) from dst will do.
+		if n < 28 || binary.BigEndian.Uint16(buf[6:8]) != 2 || !net.IP(buf[14:18]).Equal(dst) {
+			continue
+		}
+		return net.HardwareAddr(append([]byte(nil), buf[8:14]...)), elapsed, nil
+	}
+}
+
+// neighborSolicitation sends an IPv6 neighbor solicitation for dst to its
+// solicited-node multicast group on iface and waits for the advertisement.
+func neighborSolicitation(iface *net.Interface, dst net.IP, timeout time.Duration) (net.HardwareAddr, time.Duration, error) {
+	fd, err := syscall.Socket(syscall.AF_INET6, syscall.SOCK_RAW, syscall.IPPROTO_ICMPV6)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer syscall.Close(fd)
+	// Neighbor discovery messages are only accepted with a hop limit of
+	// 255. The kernel fills in the ICMPv6 checksum.
+	syscall.SetsockoptInt(fd, syscall.IPPROTO_IPV6, syscall.IPV6_MULTICAST_HOPS, 255)
+	syscall.SetsockoptInt(fd, syscall.IPPROTO_IPV6, syscall.IPV6_MULTICAST_IF, iface.Index)
+	if err := syscall.BindToDevice(fd, iface.Name); err != nil {
+		return nil, 0, err
+	}
+
+	msg := make([]byte, 32)
+	msg[0] = ndpNeighborSolicitation
+	copy(msg[8:24], dst)
+	msg[24], msg[25] = ndpSourceLinkAddress, 1 // option length in 8 byte units
+	copy(msg[26:32], iface.HardwareAddr)
+
+	group := &syscall.SockaddrInet6{ZoneId: uint32(iface.Index)}
+	copy(group.Addr[:], net.IP{0xff, 0x02, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 0xff, dst[13], dst[14], dst[15]})
+
+	start := time.Now()
+	if err := syscall.Sendto(fd, msg, 0, group); err != nil {
+		return nil, 0, err
+	}
+	buf := make([]byte, 1500)
+	for {
+		n, err := recvUntil(fd, buf, start, timeout)
+		elapsed := time.Since(start)
+		if err == syscall.EAGAIN {
+			return nil, elapsed, neighborTimeout("no neighbor advertisement received: i/o timeout")
+		} else if err != nil {
+			return nil, elapsed, err
+		}
+		if n < 24 || buf[0] != ndpNeighborAdvertisement || !net.IP(buf[8:24]).Equal(dst) {
+			continue
+		}
+		for opt := buf[24:n]; len(opt) >= 8 && opt[1] > 0 && len(opt) >= int(opt[1])*8; opt = opt[int(opt[1])*8:] {
+			if opt[0] == ndpTargetLinkAddress {
+				return net.HardwareAddr(append([]byte(nil), opt[2:8]...)), elapsed, nil
+			}
+		}
+		// Solicited advertisements may leave out the address, the
+		// answer still shows that the neighbor is there.
+		return net.HardwareAddr{}, elapsed, nil
+	}
+}
+
+// recvUntil reads one packet from fd, waiting until timeout after start at
+// most. It returns EAGAIN once the time is up. As for a TCP connect, a
+// timeout of 0 waits as long as it takes.
+func recvUntil(fd int, buf []byte, start time.Time, timeout time.Duration) (int, error) {
+	for {
+		var tv syscall.Timeval
+		if timeout > 0 {
+			left := time.Until(start.Add(timeout))
+			if left <= 0 {
+				return 0, syscall.EAGAIN
+			}
+			if tv = syscall.NsecToTimeval(left.Nanoseconds()); tv.Sec == 0 && tv.Usec == 0 {
+				tv.Usec = 1
+			}
+		}
+		syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv)
+		n, _, err := syscall.Recvfrom(fd, buf, 0)
+		if err == syscall.EAGAIN || err == syscall.EINTR {
+			continue
+		}
+		return n, err
+	}
+}
+
+// neighborTimeout is the error for a neighbor that did not answer in time.
+// Like the errors of a dial, it reports itself as a timeout.
+type neighborTimeout string
+
+func (e neighborTimeout) Error() string { return string(e) }
+func (neighborTimeout) Timeout() bool   { return true }
+func (neighborTimeout) Temporary() bool { return true }
+
+// htons converts v to network byte order, as the packet socket calls expect
+// the protocol.
+func htons(v uint16) uint16 {
+	b := [2]byte{byte(v >> 8), byte(v)}
+	return *(*uint16)(unsafe.Pointer(&b[0]))
+}
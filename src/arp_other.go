@@ -0,0 +1,10 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// newARPProbe is not supported on this platform.
+func newARPProbe(targets []*target, probe probeFunc) (probeFunc, error) {
+	return nil, errors.New("--arp is only supported on Linux")
+}
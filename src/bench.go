@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// runBench probes t from concurrency workers as fast as possible for the
+// given duration, with plain TCP pings opening and closing a connection each
+// time, and reports the achieved connection rate, the errors and the latency
+// percentiles. The results are kept in t.stats for --summary-file.
+func runBench(t *target, probe probeFunc, duration time.Duration, concurrency int, timeout time.Duration) {
+	fmt.Printf("Benchmarking %s for %s with %d workers...\n", t, duration, concurrency)
+
+	var mu sync.Mutex
+	errs := make(map[string]int)
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+
+	start := time.Now()
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		// Every worker has its own copy of the target, probes keep per
+		// target state.
+		wt := *t
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				elapsed, _, err := probe(&wt, timeout)
+				mu.Lock()
+				t.stats.add(elapsed, err)
+				if err != nil {
+					errs[errorClass(err)]++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(interrupt)
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	deadline := time.After(duration)
+
+	last := 0
+loop:
+	for {
+		select {
+		case <-ticker.C:
+			mu.Lock()
+			sent, failed := t.stats.sentCount, t.stats.sentCount-t.stats.respondedCount
+			mu.Unlock()
+			fmt.Printf("[%s] %d conn/s, %d connections, %d failed\n",
+				time.Since(start).Round(time.Second), sent-last, sent, failed)
+			last = sent
+		case <-deadline:
+			break loop
+		case <-interrupt:
+			fmt.Println("\nBenchmark interrupted.")
+			break loop
+		}
+	}
+	close(stop)
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	printBenchStatistics(t, elapsed, concurrency, errs)
+}
+
+func printBenchStatistics(t *target, elapsed time.Duration, concurrency int, errs map[string]int) {
+	s := &t.stats
+	fmt.Println("")
+	fmt.Printf("--- Benchmark Statistics for %s ---\n", t)
+	fmt.Printf("%d connections in %.2fs with %d workers, %.1f conn/s\n",
+		s.sentCount, elapsed.Seconds(), concurrency, float64(s.sentCount)/elapsed.Seconds())
+
+	failed := s.sentCount - s.respondedCount
+	if failed > 0 {
+		classes := make([]string, 0, len(errs))
+		for class := range errs {
+			classes = append(classes, class)
+		}
+		sort.Slice(classes, func(i, j int) bool { return errs[classes[i]] > errs[classes[j]] })
+		breakdown := make([]string, len(classes))
+		for i, class := range classes {
+			breakdown[i] = fmt.Sprintf("%d %s", errs[class], class)
+		}
+		fmt.Printf("%d succeeded, %d failed (%.2f%%): %s\n", s.respondedCount, failed, s.loss(), strings.Join(breakdown, ", "))
+	} else {
+		fmt.Printf("%d succeeded, 0 failed\n", s.respondedCount)
+	}
+
+	if s.respondedCount > 0 {
+		fmt.Printf("min/avg/max = %s/%s/%s\n", formatRTT(s.minTime), formatRTT(s.avg()), formatRTT(s.maxTime))
+		fmt.Printf("p50/p90/p95/p99 = %s/%s/%s/%s\n", formatRTT(s.median()),
+			formatRTT(s.percentile(90)), formatRTT(s.percentile(95)), formatRTT(s.percentile(99)))
+	}
+}
@@ -0,0 +1,472 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// chartRecorder keeps the time series of every ping for --chart.
+type chartRecorder struct {
+	path   string
+	start  time.Time
+	series map[*target][]chartPoint
+}
+
+// chartPoint is one ping, at its offset from the first ping of the run. Lost
+// pings have a negative RTT.
+type chartPoint struct {
+	at  time.Duration
+	rtt time.Duration
+}
+
+func newChartRecorder(path string) (*chartRecorder, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".png", ".svg":
+	default:
+		return nil, fmt.Errorf("--chart must be a .png or .svg file, got %q", path)
+	}
+	return &chartRecorder{path: path, series: make(map[*target][]chartPoint)}, nil
+}
+
+func (c *chartRecorder) add(t *target, sentAt time.Time, elapsed time.Duration, err error) {
+	if c.start.IsZero() {
+		c.start = sentAt
+	}
+	rtt := elapsed
+	if err != nil {
+		rtt = -1
+	}
+	c.series[t] = append(c.series[t], chartPoint{at: sentAt.Sub(c.start), rtt: rtt})
+}
+
+// reset drops the time series when the statistics are reset, so the chart
+// starts over as well.
+func (c *chartRecorder) reset() {
+	c.start = time.Time{}
+	c.series = make(map[*target][]chartPoint)
+}
+
+// write renders the RTTs of all targets over time with the lost pings shaded
+// behind them and saves the chart as PNG or SVG, depending on the extension
+// of the path.
+func (c *chartRecorder) write(targets []*target) error {
+	height := chartTop + chartPlotHeight + 32 + (len(targets)+1)*chartLine
+	if strings.ToLower(filepath.Ext(c.path)) == ".svg" {
+		cv := &svgCanvas{}
+		fmt.Fprintf(&cv.buf, "<svg xmlns=\"http://www.w3.org/2000/svg\" width=\"%d\" height=\"%d\" viewBox=\"0 0 %d %d\">\n",
+			chartWidth, height, chartWidth, height)
+		c.render(cv, targets, height)
+		cv.buf.WriteString("</svg>\n")
+		return os.WriteFile(c.path, cv.buf.Bytes(), 0644)
+	}
+
+	cv := &pngCanvas{img: image.NewRGBA(image.Rect(0, 0, chartWidth, height))}
+	c.render(cv, targets, height)
+	f, err := os.Create(c.path)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(f, cv.img); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+const (
+	chartWidth      = 960
+	chartPlotHeight = 300
+	chartLeft       = 80
+	chartRight      = 40
+	chartTop        = 36
+	chartLine       = 18
+)
+
+var (
+	chartBackground = color.NRGBA{255, 255, 255, 255}
+	chartInk        = color.NRGBA{40, 40, 40, 255}
+	chartGrid       = color.NRGBA{225, 225, 225, 255}
+	chartLoss       = color.NRGBA{220, 40, 40, 255}
+	chartColors     = []color.NRGBA{
+		{31, 119, 180, 255},
+		{255, 127, 14, 255},
+		{44, 160, 44, 255},
+		{148, 103, 189, 255},
+		{140, 86, 75, 255},
+		{227, 119, 194, 255},
+		{127, 127, 127, 255},
+		{23, 190, 207, 255},
+	}
+)
+
+// chartSlotWidth is the narrowest time slot for the loss shading in pixels,
+// wide enough for the slots of a long run to cover several pings each.
+const chartSlotWidth = 4
+
+// chartBin sums up the pings of a target that fall on one column of the plot,
+// so long runs are averaged down to the width of the chart.
+type chartBin struct {
+	sent, lost int
+	total      time.Duration
+}
+
+func (c *chartRecorder) render(cv chartCanvas, targets []*target, height int) {
+	plotWidth := chartWidth - chartLeft - chartRight
+	bottom := chartTop + chartPlotHeight
+	cv.rect(0, 0, chartWidth, height, chartBackground)
+
+	var span time.Duration
+	most := 1
+	for _, points := range c.series {
+		if len(points) > 0 && points[len(points)-1].at > span {
+			span = points[len(points)-1].at
+		}
+		if len(points) > most {
+			most = len(points)
+		}
+	}
+	column := func(at time.Duration) int {
+		if span <= 0 {
+			return 0
+		}
+		return int(float64(at)/float64(span)*float64(plotWidth-1) + 0.5)
+	}
+
+	bins := make(map[*target][]chartBin)
+	maxRTT := 0.0
+	for _, t := range targets {
+		b := make([]chartBin, plotWidth)
+		for _, p := range c.series[t] {
+			i := column(p.at)
+			b[i].sent++
+			if p.rtt < 0 {
+				b[i].lost++
+			} else {
+				b[i].total += p.rtt
+			}
+		}
+		for _, bin := range b {
+			if bin.sent > bin.lost {
+				maxRTT = math.Max(maxRTT, chartValue(bin.total/time.Duration(bin.sent-bin.lost)))
+			}
+		}
+		bins[t] = b
+	}
+
+	end := c.start.Add(span)
+	cv.text(chartLeft, 12, fmt.Sprintf("tcping %s - %s", c.start.Format("2006-01-02 15:04:05"), end.Format("2006-01-02 15:04:05")),
+		textStart, chartInk)
+
+	// Horizontal grid lines at round values.
+	if maxRTT <= 0 {
+		maxRTT = 1
+	}
+	step := chartStep(maxRTT / 5)
+	steps := int(math.Ceil(maxRTT/step - 1e-9))
+	if steps < 1 {
+		steps = 1
+	}
+	yMax := step * float64(steps)
+	decimals := 0
+	if step < 1 {
+		decimals = int(math.Ceil(-math.Log10(step) - 1e-9))
+	}
+	y := func(v float64) int {
+		return bottom - int(v/yMax*chartPlotHeight+0.5)
+	}
+	for i := 0; i <= steps; i++ {
+		v := step * float64(i)
+		cv.line(chartLeft, y(v), chartLeft+plotWidth-1, y(v), 1, chartGrid)
+		cv.text(chartLeft-6, y(v)-5, fmt.Sprintf("%.*f%s", decimals, v, rttSuffix), textEnd, chartInk)
+	}
+
+	// Vertical grid lines with the time of day.
+	layout := "15:04:05"
+	if span >= 24*time.Hour {
+		layout = "01-02 15:04"
+	}
+	for i := 0; i <= 4; i++ {
+		x := chartLeft + (plotWidth-1)*i/4
+		cv.line(x, chartTop, x, bottom, 1, chartGrid)
+		cv.text(x, bottom+8, c.start.Add(span*time.Duration(i)/4).Format(layout), textMiddle, chartInk)
+	}
+
+	// Lost pings shade the time slots they fall in, the darker the larger
+	// their share. Every target has a band of its own, so the shades of
+	// several targets do not add up.
+	slots := most
+	if slots > plotWidth/chartSlotWidth {
+		slots = plotWidth / chartSlotWidth
+	}
+	slot := func(at time.Duration) int {
+		if span <= 0 {
+			return 0
+		}
+		i := int(float64(at) / float64(span) * float64(slots))
+		if i >= slots {
+			i = slots - 1
+		}
+		return i
+	}
+	for n, t := range targets {
+		loss := make([]chartBin, slots)
+		for _, p := range c.series[t] {
+			i := slot(p.at)
+			loss[i].sent++
+			if p.rtt < 0 {
+				loss[i].lost++
+			}
+		}
+		top := chartTop + chartPlotHeight*n/len(targets)
+		h := chartTop + chartPlotHeight*(n+1)/len(targets) - top
+		for i, bin := range loss {
+			if bin.lost == 0 {
+				continue
+			}
+			x := chartLeft + plotWidth*i/slots
+			w := chartLeft + plotWidth*(i+1)/slots - x
+			shade := chartLoss
+			shade.A = uint8(60 + 140*bin.lost/bin.sent)
+			cv.rect(x, top, w, h, shade)
+		}
+	}
+
+	for n, t := range targets {
+		col := chartColors[n%len(chartColors)]
+		// Mark the single pings when there are few enough to tell apart.
+		dots := len(c.series[t]) <= plotWidth/8
+		prevX, prevY, connected := 0, 0, false
+		for i, bin := range bins[t] {
+			if bin.sent == 0 {
+				continue
+			}
+			if bin.lost == bin.sent {
+				connected = false
+				continue
+			}
+			x, py := chartLeft+i, y(chartValue(bin.total/time.Duration(bin.sent-bin.lost)))
+			if connected {
+				cv.line(prevX, prevY, x, py, 2, col)
+			}
+			if dots {
+				cv.rect(x-2, py-2, 5, 5, col)
+			}
+			prevX, prevY, connected = x, py, true
+		}
+	}
+
+	cv.line(chartLeft, chartTop, chartLeft, bottom, 1, chartInk)
+	cv.line(chartLeft, bottom, chartLeft+plotWidth-1, bottom, 1, chartInk)
+
+	legend := bottom + 32
+	for n, t := range targets {
+		points := c.series[t]
+		lost, total := 0, time.Duration(0)
+		for _, p := range points {
+			if p.rtt < 0 {
+				lost++
+			} else {
+				total += p.rtt
+			}
+		}
+		line := fmt.Sprintf("%s  %d pings", t, len(points))
+		if len(points) > 0 {
+			line += fmt.Sprintf(", loss %.2f%%", float64(lost)/float64(len(points))*100)
+		}
+		if lost < len(points) {
+			line += fmt.Sprintf(", avg %s", formatRTT(total/time.Duration(len(points)-lost)))
+		}
+		cv.rect(chartLeft, legend+n*chartLine, 10, 10, chartColors[n%len(chartColors)])
+		cv.text(chartLeft+18, legend+n*chartLine, line, textStart, chartInk)
+	}
+	shade := chartLoss
+	shade.A = 130
+	cv.rect(chartLeft, legend+len(targets)*chartLine, 10, 10, shade)
+	cv.text(chartLeft+18, legend+len(targets)*chartLine, "lost pings", textStart, chartInk)
+}
+
+// chartValue converts d to the unit of --unit.
+func chartValue(d time.Duration) float64 {
+	return float64(d) / float64(rttUnit)
+}
+
+// chartStep rounds x up to 1, 2 or 5 times a power of ten.
+func chartStep(x float64) float64 {
+	p := math.Pow(10, math.Floor(math.Log10(x)))
+	for _, m := range []float64{1, 2, 5} {
+		if m*p >= x*(1-1e-9) {
+			return m * p
+		}
+	}
+	return 10 * p
+}
+
+const (
+	textStart = iota
+	textMiddle
+	textEnd
+)
+
+// chartCanvas is what the chart is drawn on. Coordinates are in pixels from
+// the top left corner; text is placed by its top edge.
+type chartCanvas interface {
+	rect(x, y, w, h int, c color.NRGBA)
+	line(x1, y1, x2, y2, width int, c color.NRGBA)
+	text(x, y int, s string, anchor int, c color.NRGBA)
+}
+
+type svgCanvas struct {
+	buf bytes.Buffer
+}
+
+func svgColor(c color.NRGBA) string {
+	s := fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+	if c.A != 255 {
+		s += fmt.Sprintf("\" fill-opacity=\"%.2f", float64(c.A)/255)
+	}
+	return s
+}
+
+func (cv *svgCanvas) rect(x, y, w, h int, c color.NRGBA) {
+	fmt.Fprintf(&cv.buf, "<rect x=\"%d\" y=\"%d\" width=\"%d\" height=\"%d\" fill=\"%s\"/>\n", x, y, w, h, svgColor(c))
+}
+
+func (cv *svgCanvas) line(x1, y1, x2, y2, width int, c color.NRGBA) {
+	fmt.Fprintf(&cv.buf, "<line x1=\"%d\" y1=\"%d\" x2=\"%d\" y2=\"%d\" stroke=\"#%02x%02x%02x\" stroke-width=\"%d\"/>\n",
+		x1, y1, x2, y2, c.R, c.G, c.B, width)
+}
+
+func (cv *svgCanvas) text(x, y int, s string, anchor int, c color.NRGBA) {
+	anchors := []string{"start", "middle", "end"}
+	fmt.Fprintf(&cv.buf, "<text x=\"%d\" y=\"%d\" font-family=\"monospace\" font-size=\"12\" dominant-baseline=\"hanging\" text-anchor=\"%s\" fill=\"%s\">%s</text>\n",
+		x, y, anchors[anchor], svgColor(c), html.EscapeString(s))
+}
+
+// pngCanvas draws on an image. Without a font in the standard library, text
+// uses the small built-in glyphs of chartGlyphs, scaled by two.
+type pngCanvas struct {
+	img *image.RGBA
+}
+
+func (cv *pngCanvas) rect(x, y, w, h int, c color.NRGBA) {
+	draw.Draw(cv.img, image.Rect(x, y, x+w, y+h), image.NewUniform(c), image.Point{}, draw.Over)
+}
+
+func (cv *pngCanvas) line(x1, y1, x2, y2, width int, c color.NRGBA) {
+	// Bresenham, with each point drawn as a width by width square.
+	dx, dy := x2-x1, y2-y1
+	sx, sy := 1, 1
+	if dx < 0 {
+		dx, sx = -dx, -1
+	}
+	if dy < 0 {
+		dy, sy = -dy, -1
+	}
+	e := dx - dy
+	for {
+		cv.rect(x1, y1, width, width, c)
+		if x1 == x2 && y1 == y2 {
+			return
+		}
+		if e2 := 2 * e; e2 > -dy {
+			e -= dy
+			x1 += sx
+		} else {
+			e += dx
+			y1 += sy
+		}
+	}
+}
+
+func (cv *pngCanvas) text(x, y int, s string, anchor int, c color.NRGBA) {
+	s = strings.ToLower(s)
+	width := utf8.RuneCountInString(s)*8 - 2
+	switch anchor {
+	case textMiddle:
+		x -= width / 2
+	case textEnd:
+		x -= width
+	}
+	for _, r := range s {
+		glyph, ok := chartGlyphs[r]
+		if !ok && r != ' ' {
+			glyph = chartUnknownGlyph
+		}
+		for row, bits := range glyph {
+			for col, bit := range bits {
+				if bit == '#' {
+					cv.rect(x+col*2, y+row*2, 2, 2, c)
+				}
+			}
+		}
+		x += 8
+	}
+}
+
+// chartGlyphs are 3x5 pixel capitals for the letters and digits and the
+// punctuation of addresses, URLs and times. Other characters are drawn as
+// chartUnknownGlyph rather than left out.
+var chartGlyphs = map[rune][5]string{
+	'0': {"###", "#.#", "#.#", "#.#", "###"},
+	'1': {".#.", "##.", ".#.", ".#.", "###"},
+	'2': {"###", "..#", "###", "#..", "###"},
+	'3': {"###", "..#", "###", "..#", "###"},
+	'4': {"#.#", "#.#", "###", "..#", "..#"},
+	'5': {"###", "#..", "###", "..#", "###"},
+	'6': {"###", "#..", "###", "#.#", "###"},
+	'7': {"###", "..#", "..#", "..#", "..#"},
+	'8': {"###", "#.#", "###", "#.#", "###"},
+	'9': {"###", "#.#", "###", "..#", "###"},
+	'a': {".#.", "#.#", "###", "#.#", "#.#"},
+	'b': {"##.", "#.#", "##.", "#.#", "##."},
+	'c': {".##", "#..", "#..", "#..", ".##"},
+	'd': {"##.", "#.#", "#.#", "#.#", "##."},
+	'e': {"###", "#..", "##.", "#..", "###"},
+	'f': {"###", "#..", "##.", "#..", "#.."},
+	'g': {".##", "#..", "#.#", "#.#", ".##"},
+	'h': {"#.#", "#.#", "###", "#.#", "#.#"},
+	'i': {"###", ".#.", ".#.", ".#.", "###"},
+	'j': {"..#", "..#", "..#", "#.#", ".#."},
+	'k': {"#.#", "#.#", "##.", "#.#", "#.#"},
+	'l': {"#..", "#..", "#..", "#..", "###"},
+	'm': {"#.#", "###", "###", "#.#", "#.#"},
+	'n': {"##.", "#.#", "#.#", "#.#", "#.#"},
+	'o': {".#.", "#.#", "#.#", "#.#", ".#."},
+	'p': {"##.", "#.#", "##.", "#..", "#.."},
+	'q': {".#.", "#.#", "#.#", "##.", ".##"},
+	'r': {"##.", "#.#", "##.", "#.#", "#.#"},
+	's': {".##", "#..", ".#.", "..#", "##."},
+	't': {"###", ".#.", ".#.", ".#.", ".#."},
+	'u': {"#.#", "#.#", "#.#", "#.#", "###"},
+	'v': {"#.#", "#.#", "#.#", "#.#", ".#."},
+	'w': {"#.#", "#.#", "###", "###", "#.#"},
+	'x': {"#.#", "#.#", ".#.", "#.#", "#.#"},
+	'y': {"#.#", "#.#", ".#.", ".#.", ".#."},
+	'z': {"###", "..#", ".#.", "#..", "###"},
+	'-': {"...", "...", "###", "...", "..."},
+	'.': {"...", "...", "...", "...", ".#."},
+	',': {"...", "...", "...", ".#.", "#.."},
+	':': {"...", ".#.", "...", ".#.", "..."},
+	'/': {"..#", "..#", ".#.", "#..", "#.."},
+	'_': {"...", "...", "...", "...", "###"},
+	'=': {"...", "###", "...", "###", "..."},
+	'+': {"...", ".#.", "###", ".#.", "..."},
+	'%': {"#.#", "..#", ".#.", "#..", "#.#"},
+	'[': {"##.", "#..", "#..", "#..", "##."},
+	']': {".##", "..#", "..#", "..#", ".##"},
+	'(': {".#.", "#..", "#..", "#..", ".#."},
+	')': {".#.", "..#", "..#", "..#", ".#."},
+}
+
+var chartUnknownGlyph = [5]string{"###", "..#", ".##", "...", ".#."}
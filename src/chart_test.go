@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"image/color"
+	"testing"
+	"time"
+)
+
+// recordingCanvas keeps the loss shading a chart draws on the plot, leaving
+// out the legend below it.
+type recordingCanvas struct {
+	shades []shadeRect
+}
+
+type shadeRect struct {
+	x, y, w, h int
+	alpha      uint8
+}
+
+func (cv *recordingCanvas) rect(x, y, w, h int, c color.NRGBA) {
+	if c.R == chartLoss.R && c.G == chartLoss.G && c.B == chartLoss.B && y < chartTop+chartPlotHeight {
+		cv.shades = append(cv.shades, shadeRect{x, y, w, h, c.A})
+	}
+}
+
+func (cv *recordingCanvas) line(x1, y1, x2, y2, width int, c color.NRGBA)      {}
+func (cv *recordingCanvas) text(x, y int, s string, anchor int, c color.NRGBA) {}
+
+func TestChartLossShading(t *testing.T) {
+	lost := errors.New("timeout")
+	tests := []struct {
+		name     string
+		pings    int
+		lostEach int // every lostEach-th ping is lost for the first target
+		minAlpha uint8
+		maxAlpha uint8
+	}{
+		// Few pings get a slot each, the lost ones are fully shaded.
+		{"few pings", 20, 2, 200, 200},
+		// Many pings share slots, which all show about half the loss.
+		{"long run", 5000, 2, 120, 140},
+		{"all lost", 3000, 1, 200, 200},
+	}
+	for _, tt := range tests {
+		a := &target{address: "192.0.2.1", port: "80"}
+		b := &target{address: "192.0.2.2", port: "80"}
+		c, err := newChartRecorder("out.png")
+		if err != nil {
+			t.Fatal(err)
+		}
+		start := time.Date(2026, 10, 14, 10, 0, 0, 0, time.UTC)
+		for i := 0; i < tt.pings; i++ {
+			at := start.Add(time.Duration(i) * time.Second)
+			if i%tt.lostEach == 0 {
+				c.add(a, at, 0, lost)
+			} else {
+				c.add(a, at, 10*time.Millisecond, nil)
+			}
+			c.add(b, at, 20*time.Millisecond, nil)
+		}
+
+		cv := &recordingCanvas{}
+		c.render(cv, []*target{a, b}, 400)
+		if len(cv.shades) == 0 {
+			t.Errorf("%s: no loss shaded", tt.name)
+			continue
+		}
+		for i, r := range cv.shades {
+			if r.alpha < tt.minAlpha || r.alpha > tt.maxAlpha {
+				t.Errorf("%s: slot at x=%d has alpha %d, want %d-%d", tt.name, r.x, r.alpha, tt.minAlpha, tt.maxAlpha)
+			}
+			// Only the band of the first target, the second lost nothing.
+			if r.y != chartTop || r.h != chartPlotHeight/2 {
+				t.Errorf("%s: slot at x=%d covers y %d+%d, want the upper half of the plot", tt.name, r.x, r.y, r.h)
+			}
+			if i > 0 && r.x < cv.shades[i-1].x+cv.shades[i-1].w {
+				t.Errorf("%s: slot at x=%d overlaps the one before", tt.name, r.x)
+			}
+		}
+	}
+}
+
+func TestChartGlyphs(t *testing.T) {
+	for _, r := range "abcdefghijklmnopqrstuvwxyz0123456789-.,:%[]()/_=+" {
+		if _, ok := chartGlyphs[r]; !ok {
+			t.Errorf("no glyph for %q", r)
+		}
+	}
+}
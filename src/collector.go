@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// collector aggregates the results streamed by agents per target and per
+// agent.
+type collector struct {
+	mu      sync.Mutex
+	targets map[string]map[string]*agentView
+}
+
+// agentView is what a collector knows about one target as seen by one agent.
+type agentView struct {
+	stats statistics
+	last  agentResult
+}
+
+type collectorReport struct {
+	Targets []collectedTarget `json:"targets"`
+}
+
+type collectedTarget struct {
+	Target string           `json:"target"`
+	Agents []collectedAgent `json:"agents"`
+}
+
+type collectedAgent struct {
+	Agent string `json:"agent"`
+	statsSummary
+	LastSeen  time.Time `json:"last_seen"`
+	LastError string    `json:"last_error,omitempty"`
+}
+
+// runCollector implements `tcping collector`: it accepts the results of
+// `tcping agent --join` from any number of agents, prints the per-target view
+// of all agents side by side every report interval and serves it as JSON
+// with --http.
+func runCollector(args []string) int {
+	flags := flag.NewFlagSet("collector", flag.ExitOnError)
+	listen := flags.String("listen", ":"+collectorDefaultPort, "Address agents connect to")
+	httpAddr := flags.String("http", "", "Serve the aggregated results as JSON on this address, e.g. :8080")
+	report := flags.Duration("report", 10*time.Second, "How often the aggregated results are printed, 0 to not print them")
+	flags.Usage = func() {
+		fmt.Println("Usage: tcping collector [--listen :7000] [--http :8080] [--report 10s]")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	c := &collector{targets: make(map[string]map[string]*agentView)}
+	ln, err := net.Listen("tcp", *listen)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	fmt.Printf("Collecting results from agents on %s...\n", ln.Addr())
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				fmt.Println(err)
+				return
+			}
+			go c.serve(conn)
+		}
+	}()
+
+	if *httpAddr != "" {
+		fmt.Printf("Serving the results on http://%s/\n", *httpAddr)
+		go func() {
+			err := http.ListenAndServe(*httpAddr, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				enc := json.NewEncoder(w)
+				enc.SetIndent("", "  ")
+				enc.Encode(c.report())
+			}))
+			fmt.Printf("Failed to serve HTTP: %v\n", err)
+			os.Exit(1)
+		}()
+	}
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	var tick <-chan time.Time
+	if *report > 0 {
+		ticker := time.NewTicker(*report)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-tick:
+			c.print()
+		case <-interrupt:
+			fmt.Println("\nCollector stopped.")
+			c.print()
+			return 0
+		}
+	}
+}
+
+// serve reads the results of one agent until it disconnects.
+func (c *collector) serve(conn net.Conn) {
+	defer conn.Close()
+	var agent string
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		var r agentResult
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil || r.Agent == "" || r.Target == "" {
+			fmt.Printf("Invalid result from %s, disconnecting\n", conn.RemoteAddr())
+			return
+		}
+		if agent == "" {
+			agent = r.Agent
+			fmt.Printf("Agent %s connected from %s\n", agent, conn.RemoteAddr())
+		}
+		c.add(r)
+	}
+	if agent != "" {
+		fmt.Printf("Agent %s disconnected\n", agent)
+	}
+}
+
+func (c *collector) add(r agentResult) {
+	var err error
+	if r.Unexpected {
+		err = &mismatchError{r.Error}
+	} else if r.Error != "" {
+		err = errors.New(r.Error)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	agents := c.targets[r.Target]
+	if agents == nil {
+		agents = make(map[string]*agentView)
+		c.targets[r.Target] = agents
+	}
+	view := agents[r.Agent]
+	if view == nil {
+		view = &agentView{}
+		agents[r.Agent] = view
+	}
+	view.stats.add(time.Duration(r.RTT*float64(time.Millisecond)), err)
+	view.last = r
+}
+
+// report returns the aggregated results sorted by target and agent.
+func (c *collector) report() collectorReport {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	report := collectorReport{Targets: []collectedTarget{}}
+	for name, agents := range c.targets {
+		ct := collectedTarget{Target: name}
+		for agent, view := range agents {
+			ct.Agents = append(ct.Agents, collectedAgent{
+				Agent:        agent,
+				statsSummary: summarize(view.stats),
+				LastSeen:     view.last.Time,
+				LastError:    view.last.Error,
+			})
+		}
+		sort.Slice(ct.Agents, func(i, j int) bool { return ct.Agents[i].Agent < ct.Agents[j].Agent })
+		report.Targets = append(report.Targets, ct)
+	}
+	sort.Slice(report.Targets, func(i, j int) bool { return report.Targets[i].Target < report.Targets[j].Target })
+	return report
+}
+
+func (c *collector) print() {
+	report := c.report()
+	if len(report.Targets) == 0 {
+		fmt.Println("No results received yet.")
+		return
+	}
+	fmt.Println("")
+	for _, t := range report.Targets {
+		fmt.Printf("--- %s ---\n", t.Target)
+		fmt.Printf("%-20s %8s %8s  %-24s %s\n", "agent", "sent", "loss", "min/avg/max", "last")
+		for _, a := range t.Agents {
+			latency := "-"
+			if a.Latency != nil {
+				latency = fmt.Sprintf("%.1f/%.1f/%.1fms", a.Latency.Min, a.Latency.Avg, a.Latency.Max)
+			}
+			last := "ok"
+			if a.LastError != "" {
+				last = a.LastError
+			}
+			fmt.Printf("%-20s %8d %7.2f%%  %-24s %s (%s ago)\n", a.Agent, a.Sent, a.Loss, latency, last,
+				time.Since(a.LastSeen).Round(time.Second))
+		}
+	}
+}
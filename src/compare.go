@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// comparison probes two targets in lockstep and prints their results in
+// aligned columns. The results are accounted with record like those of the
+// other modes, so outages, alerts and exports see them too.
+type comparison struct {
+	a, b    *target
+	probe   probeFunc
+	record  func(t *target, elapsed time.Duration, err error, sentAt time.Time)
+	timeout time.Duration
+	width   int
+	seq     int
+
+	// faster counts the rounds each side won when both responded.
+	fasterA, fasterB int
+}
+
+func newComparison(a, b *target, probe probeFunc, timeout time.Duration, record func(t *target, elapsed time.Duration, err error, sentAt time.Time)) *comparison {
+	width := len(a.String())
+	if l := len(b.String()); l > width {
+		width = l
+	}
+	return &comparison{a: a, b: b, probe: probe, record: record, timeout: timeout, width: width}
+}
+
+// round probes both targets concurrently and prints one row with the RTT of
+// each and the difference between them. Warmup rounds are printed but not
+// recorded.
+func (c *comparison) round(warmup bool) {
+	if c.seq == 0 {
+		fmt.Printf("%-5s %-*s %-*s %s\n", "seq", c.width, c.a, c.width, c.b, "diff (B-A)")
+	}
+	c.seq++
+
+	var wg sync.WaitGroup
+	var elapsedA, elapsedB time.Duration
+	var errA, errB error
+	sentAt := time.Now()
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		elapsedA, _, errA = c.probe(c.a, c.timeout)
+	}()
+	go func() {
+		defer wg.Done()
+		elapsedB, _, errB = c.probe(c.b, c.timeout)
+	}()
+	wg.Wait()
+
+	diff := "-"
+	if errA == nil && errB == nil {
+		diff = formatRTTDelta(elapsedB - elapsedA)
+	}
+	if warmup {
+		diff += " (warmup)"
+	} else {
+		c.recordRound(elapsedA, errA, elapsedB, errB, sentAt)
+	}
+	fmt.Printf("%-5d %-*s %-*s %s\n", c.seq, c.width, compareResult(elapsedA, errA), c.width, compareResult(elapsedB, errB), diff)
+}
+
+func (c *comparison) recordRound(elapsedA time.Duration, errA error, elapsedB time.Duration, errB error, sentAt time.Time) {
+	c.record(c.a, elapsedA, errA, sentAt)
+	c.record(c.b, elapsedB, errB, sentAt)
+	if errA != nil || errB != nil {
+		return
+	}
+	if elapsedA < elapsedB {
+		c.fasterA++
+	} else if elapsedB < elapsedA {
+		c.fasterB++
+	}
+}
+
+func compareResult(elapsed time.Duration, err error) string {
+	if err != nil {
+		return "failed"
+	}
+	return formatRTT(elapsed)
+}
+
+// summary prints the statistics of both targets followed by which one was
+// faster and by how much.
+func (c *comparison) summary() {
+	for _, t := range []*target{c.a, c.b} {
+		printTcpingStatistics(fmt.Sprintf("--- Tcping Statistics for %s ---", t), t.stats)
+	}
+
+	fmt.Println("")
+	fmt.Println("--- Comparison ---")
+	if c.a.stats.sentCount == 0 {
+		fmt.Println("No tcp ping sent.")
+		return
+	}
+	fmt.Printf("loss delta (B-A) = %+.2f%%\n", c.b.stats.loss()-c.a.stats.loss())
+	fmt.Printf("faster rounds: %s %d, %s %d\n", c.a, c.fasterA, c.b, c.fasterB)
+
+	if c.a.stats.respondedCount == 0 || c.b.stats.respondedCount == 0 {
+		fmt.Println("Not enough responses to compare latency.")
+	} else {
+		avgA, avgB := c.a.stats.avg(), c.b.stats.avg()
+		switch {
+		case avgA < avgB:
+			fmt.Printf("%s was faster by %s on average\n", c.a, formatRTT(avgB-avgA))
+		case avgB < avgA:
+			fmt.Printf("%s was faster by %s on average\n", c.b, formatRTT(avgA-avgB))
+		default:
+			fmt.Println("Both targets had the same average latency")
+		}
+	}
+
+	if healthier := c.healthier(); healthier != nil {
+		fmt.Printf("%s was healthier\n", healthier)
+	}
+}
+
+// healthier returns the target with the lower loss, falling back to the lower
+// average latency, or nil if they cannot be told apart.
+func (c *comparison) healthier() *target {
+	lossA, lossB := c.a.stats.loss(), c.b.stats.loss()
+	if lossA < lossB {
+		return c.a
+	} else if lossB < lossA {
+		return c.b
+	}
+
+	if c.a.stats.respondedCount == 0 {
+		return nil
+	}
+	avgA, avgB := c.a.stats.avg(), c.b.stats.avg()
+	if avgA < avgB {
+		return c.a
+	} else if avgB < avgA {
+		return c.b
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestComparisonRecordsResults(t *testing.T) {
+	a := &target{address: "192.0.2.1", port: "80"}
+	b := &target{address: "192.0.2.2", port: "80"}
+	probe := func(t *target, timeout time.Duration) (time.Duration, string, error) {
+		if t == a {
+			return 0, "", errors.New("connection refused")
+		}
+		return 5 * time.Millisecond, "", nil
+	}
+	recorded := make(map[*target]int)
+	record := func(t *target, elapsed time.Duration, err error, sentAt time.Time) {
+		recorded[t]++
+		t.stats.add(elapsed, err)
+		t.outages.record(sentAt, err == nil)
+	}
+
+	c := newComparison(a, b, probe, time.Second, record)
+	c.round(true)
+	c.round(false)
+	c.round(false)
+
+	// The warmup round is only printed.
+	if recorded[a] != 2 || recorded[b] != 2 {
+		t.Errorf("recorded %d results of A and %d of B, want 2 each", recorded[a], recorded[b])
+	}
+	if a.stats.respondedCount != 0 || b.stats.respondedCount != 2 {
+		t.Errorf("responded A %d, B %d, want 0 and 2", a.stats.respondedCount, b.stats.respondedCount)
+	}
+	if downA, _ := a.outages.down(); !downA {
+		t.Error("A is not down after failing every round")
+	}
+	if downB, _ := b.outages.down(); downB {
+		t.Error("B is down after responding every round")
+	}
+	if c.fasterA != 0 || c.fasterB != 0 {
+		t.Errorf("faster rounds A %d, B %d, want none as A never responded", c.fasterA, c.fasterB)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronNext(t *testing.T) {
+	// A Wednesday.
+	now := time.Date(2026, 10, 14, 10, 7, 30, 0, time.UTC)
+	date := func(month time.Month, day, hour, minute int) time.Time {
+		return time.Date(2026, month, day, hour, minute, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		expr string
+		from time.Time
+		want time.Time
+	}{
+		{"* * * * *", now, date(10, 14, 10, 8)},
+		{"*/5 * * * *", now, date(10, 14, 10, 10)},
+		{"*/5 * * * *", date(10, 14, 10, 10), date(10, 14, 10, 15)},
+		{"0,30 * * * *", now, date(10, 14, 10, 30)},
+		{"1-30/10 * * * *", now, date(10, 14, 10, 11)},
+		{"5/15 * * * *", now, date(10, 14, 10, 20)},
+		{"0 * * * *", now, date(10, 14, 11, 0)},
+		{"@hourly", now, date(10, 14, 11, 0)},
+		{"@daily", now, date(10, 15, 0, 0)},
+		{"@weekly", now, date(10, 18, 0, 0)},
+		{"@monthly", now, date(11, 1, 0, 0)},
+		{"30 9 1 * *", now, date(11, 1, 9, 30)},
+		{"0 8-18 * * 1-5", now, date(10, 14, 11, 0)},
+		{"0 8-18 * * 1-5", date(10, 16, 18, 30), date(10, 19, 8, 0)},
+		// Sunday is both 0 and 7.
+		{"0 0 * * 7", now, date(10, 18, 0, 0)},
+		// Either the day of month or the day of week.
+		{"0 0 13 * 5", now, date(10, 16, 0, 0)},
+		{"0 0 1 1 *", now, time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 12 29 2 *", now, time.Date(2028, 2, 29, 12, 0, 0, 0, time.UTC)},
+		{"0 0 30 2 *", now, time.Time{}},
+	}
+	for _, tt := range tests {
+		c, err := parseCron(tt.expr)
+		if err != nil {
+			t.Errorf("parseCron(%q) failed: %v", tt.expr, err)
+			continue
+		}
+		if got := c.next(tt.from); !got.Equal(tt.want) {
+			t.Errorf("parseCron(%q).next(%s) = %s, want %s", tt.expr, tt.from, got, tt.want)
+		}
+	}
+}
+
+func TestParseCronErrors(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"*/x * * * *",
+		"5-1 * * * *",
+		"a * * * *",
+		"1-b * * * *",
+		"@yearly",
+	} {
+		if _, err := parseCron(expr); err == nil {
+			t.Errorf("parseCron(%q) succeeded, want an error", expr)
+		}
+	}
+}
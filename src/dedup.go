@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// deduper collapses runs of identical results of a target for --dedup: the
+// first result of a run is shown in full, the rest are counted and summed up
+// in a single line once the result changes. Successes are identical to each
+// other, failures when they failed with the same error.
+type deduper struct {
+	show  func(t *target, line string, elapsed time.Duration, err error)
+	named bool // several targets, so name them on the summary lines
+	runs  map[*target]*dedupRun
+}
+
+type dedupRun struct {
+	key     string
+	failed  bool
+	count   int // results after the one shown
+	elapsed time.Duration
+}
+
+func newDeduper(show func(t *target, line string, elapsed time.Duration, err error), targets []*target) *deduper {
+	return &deduper{show: show, named: len(targets) > 1, runs: make(map[*target]*dedupRun)}
+}
+
+func (d *deduper) result(t *target, line string, elapsed time.Duration, err error) {
+	key := ""
+	if err != nil {
+		key = err.Error()
+	}
+	if r := d.runs[t]; r != nil && r.key == key && r.failed == (err != nil) {
+		r.count++
+		r.elapsed += elapsed
+		return
+	}
+	d.flush(t)
+	d.show(t, line, elapsed, err)
+	d.runs[t] = &dedupRun{key: key, failed: err != nil}
+}
+
+// note prints a line about t that is not a ping result. The run so far is
+// summed up first so that the output stays in order; the run continues
+// after the note.
+func (d *deduper) note(t *target, line string) {
+	d.flush(t)
+	d.show(t, line, 0, nil)
+}
+
+// flush prints the summary of the current run of t, if anything was
+// collapsed.
+func (d *deduper) flush(t *target) {
+	r := d.runs[t]
+	if r == nil || r.count == 0 {
+		return
+	}
+	from := ""
+	if d.named {
+		from = " from " + t.String()
+	}
+	var line string
+	if r.failed {
+		line = fmt.Sprintf("... %d identical failures%s ...", r.count, from)
+	} else {
+		line = fmt.Sprintf("... %d identical successes%s (avg %s) ...", r.count, from, formatRTT(r.elapsed/time.Duration(r.count)))
+	}
+	d.show(t, line, 0, nil)
+	r.count, r.elapsed = 0, 0
+}
+
+// flushAll prints the summaries of all runs at the end of the run.
+func (d *deduper) flushAll(targets []*target) {
+	for _, t := range targets {
+		d.flush(t)
+	}
+}
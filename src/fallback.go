@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+var familyNames = map[string]string{"ipv4": "IPv4", "ipv6": "IPv6"}
+
+func otherFamily(version string) string {
+	if version == "ipv4" {
+		return "ipv6"
+	}
+	return "ipv4"
+}
+
+// familyFallback implements --fallback-family: after a number of consecutive
+// failed pings a target switches to its address in the other family, as a
+// dual-stack client would, and back again the same way should that family
+// fail too. The pings of each family are accounted separately.
+type familyFallback struct {
+	after  int
+	states map[*target]*fallbackState
+
+	// show prints the switches. main sends it through the same output as
+	// the ping results.
+	show func(t *target, line string)
+}
+
+type fallbackState struct {
+	addresses map[string]string // by version
+	stats     map[string]*statistics
+	failures  int
+	switches  int
+}
+
+// newFamilyFallback resolves the address of every target in the family it is
+// not pinged in. Targets given as an IP address, or whose host has no
+// address in the other family, stay where they are.
+func newFamilyFallback(targets []*target, after int) *familyFallback {
+	f := &familyFallback{
+		after:  after,
+		states: make(map[*target]*fallbackState),
+		show:   func(t *target, line string) { fmt.Println(line) },
+	}
+	for _, t := range targets {
+		other := otherFamily(t.version)
+		address, err := resolveAddress(t.host, other)
+		if err != nil {
+			fmt.Printf("%s has no %s address, --fallback-family does not apply to it\n", targetName(t), familyNames[other])
+			continue
+		}
+		f.states[t] = &fallbackState{
+			addresses: map[string]string{t.version: t.address, other: address},
+			stats:     map[string]*statistics{t.version: {}, other: {}},
+		}
+	}
+	return f
+}
+
+// record accounts a ping of t to the family it was sent to and switches t to
+// the other family once enough pings in a row failed.
+func (f *familyFallback) record(t *target, elapsed time.Duration, err error) {
+	s := f.states[t]
+	if s == nil {
+		return
+	}
+	s.stats[t.version].add(elapsed, err)
+	if err == nil {
+		s.failures = 0
+		return
+	}
+	s.failures++
+	if s.failures < f.after {
+		return
+	}
+
+	from := t.String()
+	t.version = otherFamily(t.version)
+	t.address = s.addresses[t.version]
+	t.responded = false
+	s.failures = 0
+	s.switches++
+	f.show(t, fmt.Sprintf("Falling back from %s to %s (%s) after %d failed pings in a row", from, t, familyNames[t.version], f.after))
+}
+
+// reset clears the per-family statistics of t when its statistics are
+// reset. The target stays in the family it is in.
+func (f *familyFallback) reset(t *target) {
+	if s := f.states[t]; s != nil {
+		for version := range s.stats {
+			s.stats[version] = &statistics{}
+		}
+		s.failures, s.switches = 0, 0
+	}
+}
+
+// printStatistics prints the statistics per family of the targets that
+// switched families at least once.
+func (f *familyFallback) printStatistics(targets []*target) {
+	for _, t := range targets {
+		s := f.states[t]
+		if s == nil || s.switches == 0 {
+			continue
+		}
+		for _, version := range []string{"ipv4", "ipv6"} {
+			title := fmt.Sprintf("--- Tcping Statistics for %s over %s (%s:%s) ---", targetName(t), familyNames[version], s.addresses[version], t.port)
+			printTcpingStatistics(title, *s.stats[version])
+		}
+		fmt.Printf("Address family switches: %d\n", s.switches)
+	}
+}
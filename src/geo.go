@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// geoAPI is the ip-api.com endpoint used for GeoIP and ASN lookups. The free
+// tier is only served over plain HTTP.
+const geoAPI = "http://ip-api.com/json/%s?fields=status,message,country,city,as,org"
+
+const geoTimeout = 5 * time.Second
+
+type geoInfo struct {
+	Status  string `json:"status"`
+	Message string `json:"message"`
+	Country string `json:"country"`
+	City    string `json:"city"`
+	AS      string `json:"as"`
+	Org     string `json:"org"`
+}
+
+func (g *geoInfo) String() string {
+	var parts []string
+	if location := strings.Trim(g.City+", "+g.Country, ", "); location != "" {
+		parts = append(parts, location)
+	}
+	if g.AS != "" {
+		parts = append(parts, g.AS)
+	}
+	if g.Org != "" && !strings.Contains(g.AS, g.Org) {
+		parts = append(parts, g.Org)
+	}
+	return strings.Join(parts, " / ")
+}
+
+// lookupGeo fetches the location and AS number of address.
+func lookupGeo(address string) (*geoInfo, error) {
+	client := http.Client{Timeout: geoTimeout}
+	resp, err := client.Get(fmt.Sprintf(geoAPI, strings.Trim(address, "[]")))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	var info geoInfo
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return nil, err
+	}
+	if info.Status != "success" {
+		return nil, fmt.Errorf("lookup failed: %s", info.Message)
+	}
+	return &info, nil
+}
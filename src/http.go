@@ -0,0 +1,491 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxBodySize caps how much of a response body is read and matched.
+const maxBodySize = 1 << 20
+
+// httpOptions are the --http related flags.
+type httpOptions struct {
+	path         string
+	expectStatus string
+	expectBody   string
+	method       string
+	headers      []string
+	data         string
+	follow       int
+	keepalive    bool
+}
+
+// headerFlags collects every -H given on the command line.
+type headerFlags []string
+
+func (h *headerFlags) String() string {
+	return strings.Join(*h, ", ")
+}
+
+func (h *headerFlags) Set(value string) error {
+	*h = append(*h, value)
+	return nil
+}
+
+// followLimit is --follow, which can be given alone to follow up to
+// defaultFollow redirects or as --follow=N.
+type followLimit int
+
+const defaultFollow = 10
+
+func (f *followLimit) String() string {
+	return strconv.Itoa(int(*f))
+}
+
+func (f *followLimit) Set(value string) error {
+	switch value {
+	case "true":
+		*f = defaultFollow
+	case "false":
+		*f = 0
+	default:
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid number of redirects %q", value)
+		}
+		*f = followLimit(n)
+	}
+	return nil
+}
+
+func (f *followLimit) IsBoolFlag() bool {
+	return true
+}
+
+// httpProber sends an HTTP/1.1 request after connecting, over TLS when
+// combined with --tls. The RTT recorded in the statistics runs up to the end
+// of the response body.
+type httpProber struct {
+	tls    *tlsProber
+	path   string
+	status []statusRange
+	body   *regexp.Regexp
+
+	method  string
+	host    string // Host header override
+	header  http.Header
+	payload []byte
+
+	// follow is the maximum number of redirects followed, 0 to not
+	// follow them.
+	follow int
+
+	// kept holds the connection per target with --keepalive.
+	keepalive bool
+	mu        sync.Mutex
+	kept      map[*target]*keptConn
+}
+
+type statusRange struct {
+	min, max int
+}
+
+// mismatchError is returned when a response arrived but did not match
+// --expect-status or --expect-body. Such pings are counted as failed and
+// also listed separately in the statistics.
+type mismatchError struct {
+	msg string
+}
+
+func (e *mismatchError) Error() string {
+	return e.msg
+}
+
+func newHTTPProber(tls *tlsProber, opts httpOptions) (*httpProber, error) {
+	if !strings.HasPrefix(opts.path, "/") {
+		return nil, fmt.Errorf("--path must start with /")
+	}
+	if tls != nil {
+		for _, proto := range tls.config.NextProtos {
+			if proto != "http/1.1" {
+				return nil, fmt.Errorf("--http only speaks HTTP/1.1, it cannot be combined with --alpn %s", proto)
+			}
+		}
+	}
+
+	p := &httpProber{tls: tls, path: opts.path, method: opts.method, header: make(http.Header), follow: opts.follow}
+	if opts.keepalive {
+		p.keepalive = true
+		p.kept = make(map[*target]*keptConn)
+	}
+	status, err := parseStatusRanges(opts.expectStatus)
+	if err != nil {
+		return nil, err
+	}
+	p.status = status
+	if opts.expectBody != "" {
+		re, err := regexp.Compile(opts.expectBody)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --expect-body: %v", err)
+		}
+		p.body = re
+	}
+
+	p.header.Set("User-Agent", "tcping")
+	for _, h := range opts.headers {
+		i := strings.Index(h, ":")
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid header %q, expected \"Name: value\"", h)
+		}
+		name, value := strings.TrimSpace(h[:i]), strings.TrimSpace(h[i+1:])
+		if strings.EqualFold(name, "Host") {
+			p.host = value
+			continue
+		}
+		p.header.Add(name, value)
+	}
+
+	// Like curl, --data reads the body from a file when prefixed with @ and
+	// turns the default method into POST.
+	if opts.data != "" {
+		if strings.HasPrefix(opts.data, "@") {
+			data, err := os.ReadFile(opts.data[1:])
+			if err != nil {
+				return nil, err
+			}
+			p.payload = data
+		} else {
+			p.payload = []byte(opts.data)
+		}
+		if p.method == "" {
+			p.method = "POST"
+		}
+	}
+	if p.method == "" {
+		p.method = "GET"
+	}
+	return p, nil
+}
+
+// parseStatusRanges parses an --expect-status value such as "200-299" or
+// "200,301-302".
+func parseStatusRanges(s string) ([]statusRange, error) {
+	var ranges []statusRange
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		lo, hi := part, part
+		if i := strings.Index(part, "-"); i >= 0 {
+			lo, hi = part[:i], part[i+1:]
+		}
+		min, err1 := strconv.Atoi(lo)
+		max, err2 := strconv.Atoi(hi)
+		if err1 != nil || err2 != nil || min < 100 || max > 999 || min > max {
+			return nil, fmt.Errorf("invalid --expect-status %q", s)
+		}
+		ranges = append(ranges, statusRange{min, max})
+	}
+	return ranges, nil
+}
+
+func (p *httpProber) expected(status int) bool {
+	for _, r := range p.status {
+		if status >= r.min && status <= r.max {
+			return true
+		}
+	}
+	return false
+}
+
+// url returns the URL requested from t, keeping the host given on the
+// command line for the Host header.
+func (p *httpProber) url(t *target) string {
+	scheme, port := "http", "80"
+	if p.tls != nil {
+		scheme, port = "https", "443"
+	}
+	host := t.host
+	if isIPv6(host) {
+		host = "[" + host + "]"
+	}
+	if t.port != port {
+		host += ":" + t.port
+	}
+	return scheme + "://" + host + p.path
+}
+
+// httpRequest is a single request sent by the prober: the one for the target
+// itself or one for a redirect followed from it.
+type httpRequest struct {
+	address    string // ip:port to connect to
+	url        *url.URL
+	method     string
+	host       string // Host header override
+	header     http.Header
+	payload    []byte
+	tls        *tlsProber
+	serverName string
+	close      bool // send Connection: close
+}
+
+// httpHop is the outcome of one httpRequest.
+type httpHop struct {
+	resp    *http.Response
+	content []byte
+	elapsed time.Duration // connect, handshake and response, without --ocsp
+	detail  string
+
+	tlsDetail string
+}
+
+func (p *httpProber) probe(t *target, timeout time.Duration) (time.Duration, string, error) {
+	rateLimiter.wait()
+
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	u, err := url.Parse(p.url(t))
+	if err != nil {
+		return 0, "", err
+	}
+	r := httpRequest{
+		address: t.String(),
+		url:     u,
+		method:  p.method,
+		host:    p.host,
+		header:  p.header,
+		payload: p.payload,
+		tls:     p.tls,
+	}
+	if p.tls != nil {
+		r.serverName = p.tls.serverName(t)
+	}
+
+	var total time.Duration
+	var hops []string
+	visited := map[string]bool{u.String(): true}
+	withHops := func(detail string) string {
+		return strings.Join(append(hops, detail), "; ")
+	}
+	for {
+		var hop *httpHop
+		if p.keepalive && len(hops) == 0 {
+			hop, err = p.fetchKept(t, r, deadline)
+		} else {
+			hop, err = p.fetch(r, deadline)
+		}
+		total += hop.elapsed
+		if err != nil {
+			return total, withHops(hop.detail), err
+		}
+
+		code := hop.resp.StatusCode
+		location := hop.resp.Header.Get("Location")
+		if p.follow == 0 || !isRedirect(code) || location == "" {
+			t.banner, t.httpStatus = string(hop.content), code
+			if !p.expected(code) {
+				return total, withHops(hop.detail), &mismatchError{fmt.Sprintf("status %s", hop.resp.Status)}
+			}
+			if p.body != nil && !p.body.Match(hop.content) {
+				return total, withHops(hop.detail), &mismatchError{fmt.Sprintf("body does not match %q (status %s)", p.body, hop.resp.Status)}
+			}
+			return total, withHops(hop.detail), nil
+		}
+
+		next, err := r.url.Parse(location)
+		if err != nil {
+			return total, withHops(hop.detail), &mismatchError{fmt.Sprintf("invalid redirect to %q", location)}
+		}
+		if visited[next.String()] {
+			return total, withHops(hop.detail), &mismatchError{fmt.Sprintf("redirect loop to %s", next)}
+		}
+		if len(hops) == p.follow {
+			return total, withHops(hop.detail), &mismatchError{fmt.Sprintf("too many redirects (--follow=%d)", p.follow)}
+		}
+		visited[next.String()] = true
+		hops = append(hops, fmt.Sprintf("HTTP %d in %s to %s", code, formatRTT(hop.elapsed), next))
+		if r, err = p.redirect(t, r, next, code); err != nil {
+			return total, strings.Join(hops, "; "), err
+		}
+	}
+}
+
+// remaining returns the time left until deadline, or 0 for no deadline.
+func remaining(deadline time.Time) time.Duration {
+	if deadline.IsZero() {
+		return 0
+	}
+	return time.Until(deadline)
+}
+
+func isRedirect(status int) bool {
+	switch status {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
+	}
+	return false
+}
+
+// redirect returns the request that follows a redirect from prev to next.
+// As browsers do, 301, 302 and 303 turn the request into a GET without a
+// body, and credentials are not sent on to other hosts.
+func (p *httpProber) redirect(t *target, prev httpRequest, next *url.URL, status int) (httpRequest, error) {
+	r := prev
+	r.url = next
+	r.host = ""
+	if status == http.StatusSeeOther || (status <= http.StatusFound && prev.method != "GET" && prev.method != "HEAD") {
+		r.method, r.payload = "GET", nil
+	}
+
+	hostname, port := next.Hostname(), next.Port()
+	switch next.Scheme {
+	case "http":
+		r.tls = nil
+		if port == "" {
+			port = "80"
+		}
+	case "https":
+		// Redirects to HTTPS work without --tls too, with the default
+		// settings.
+		if p.tls != nil {
+			r.tls = p.tls
+		} else {
+			r.tls, _ = newTLSProber(tlsOptions{})
+		}
+		if port == "" {
+			port = "443"
+		}
+		r.serverName = hostname
+	default:
+		return r, fmt.Errorf("cannot follow redirect to %s", next)
+	}
+
+	address := t.address
+	if hostname != t.host {
+		r.header = prev.header.Clone()
+		r.header.Del("Authorization")
+		resolved, err := resolveAddress(hostname, t.version)
+		if err != nil {
+			return r, err
+		}
+		address = resolved
+	} else if r.tls == p.tls && p.tls != nil {
+		r.serverName = p.tls.serverName(t)
+	}
+	r.address = address + ":" + port
+	return r, nil
+}
+
+// fetch sends r on a new connection and reads the response.
+func (p *httpProber) fetch(r httpRequest, deadline time.Time) (*httpHop, error) {
+	c, hop, err := p.connect(r, deadline)
+	if err != nil {
+		return hop, err
+	}
+	defer c.conn.Close()
+
+	r.close = true
+	content, resp, response, err := c.exchange(r)
+	hop.elapsed += response
+	if err != nil {
+		return hop, err
+	}
+	hop.finish(resp, content, response)
+	return hop, nil
+}
+
+// httpConn is an established connection to an HTTP server.
+type httpConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// connect opens the connection for r and completes the TLS handshake if
+// needed. The returned hop holds the setup time and details.
+func (p *httpProber) connect(r httpRequest, deadline time.Time) (*httpConn, *httpHop, error) {
+	hop := &httpHop{}
+
+	start := time.Now()
+	conn, err := net.DialTimeout("tcp", r.address, remaining(deadline))
+	connected := time.Since(start)
+	hop.elapsed = connected
+	if err != nil {
+		return nil, hop, err
+	}
+
+	if !deadline.IsZero() {
+		conn.SetDeadline(deadline)
+	}
+	hop.detail = "connect " + formatRTT(connected)
+	if r.tls != nil {
+		client, requested, err := r.tls.handshake(conn, r.serverName)
+		hop.elapsed = time.Since(start)
+		if err != nil {
+			conn.Close()
+			return nil, hop, err
+		}
+		hop.detail += ", handshake " + formatRTT(hop.elapsed-connected)
+		// The setup time excludes the --ocsp check, which may query a
+		// responder between the handshake and the request.
+		if hop.tlsDetail, err = r.tls.describe(client, requested, remaining(deadline)); err != nil {
+			conn.Close()
+			return nil, hop, err
+		}
+		conn = client
+	}
+	return &httpConn{conn: conn, reader: bufio.NewReader(conn)}, hop, nil
+}
+
+// exchange sends r over c and reads the response, returning how long that
+// took.
+func (c *httpConn) exchange(r httpRequest) ([]byte, *http.Response, time.Duration, error) {
+	sent := time.Now()
+	var body io.Reader
+	if r.payload != nil {
+		body = bytes.NewReader(r.payload)
+	}
+	req, err := http.NewRequest(r.method, r.url.String(), body)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	req.Header = r.header.Clone()
+	if r.host != "" {
+		req.Host = r.host
+	}
+	req.Close = r.close
+	if err := req.Write(c.conn); err != nil {
+		return nil, nil, time.Since(sent), fmt.Errorf("failed to send request: %w", err)
+	}
+	resp, err := http.ReadResponse(c.reader, req)
+	if err != nil {
+		return nil, nil, time.Since(sent), fmt.Errorf("failed to read response: %w", err)
+	}
+	content, err := io.ReadAll(io.LimitReader(resp.Body, maxBodySize))
+	resp.Body.Close()
+	if err != nil {
+		return nil, nil, time.Since(sent), fmt.Errorf("failed to read response body: %w", err)
+	}
+	return content, resp, time.Since(sent), nil
+}
+
+// finish completes hop with the response.
+func (hop *httpHop) finish(resp *http.Response, content []byte, response time.Duration) {
+	hop.resp = resp
+	hop.content = content
+	hop.detail += ", response " + formatRTT(response) + ", HTTP " + resp.Status
+	if hop.tlsDetail != "" {
+		hop.detail += ", " + hop.tlsDetail
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseStatusRanges(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []statusRange
+	}{
+		{"200", []statusRange{{200, 200}}},
+		{"200-399", []statusRange{{200, 399}}},
+		{"200,301-302", []statusRange{{200, 200}, {301, 302}}},
+		{" 204 , 404 ", []statusRange{{204, 204}, {404, 404}}},
+		{"100-999", []statusRange{{100, 999}}},
+	}
+	for _, tt := range tests {
+		got, err := parseStatusRanges(tt.in)
+		if err != nil {
+			t.Errorf("parseStatusRanges(%q) failed: %v", tt.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseStatusRanges(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+
+	for _, in := range []string{"", "2xx", "99", "1000", "300-200", "200-", "-299", "200,,300", "200-299-399"} {
+		if got, err := parseStatusRanges(in); err == nil {
+			t.Errorf("parseStatusRanges(%q) = %v, want an error", in, got)
+		}
+	}
+}
+
+func TestHTTPExpected(t *testing.T) {
+	ranges, err := parseStatusRanges("200,301-302")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &httpProber{status: ranges}
+	for status, want := range map[int]bool{200: true, 201: false, 300: false, 301: true, 302: true, 404: false} {
+		if got := p.expected(status); got != want {
+			t.Errorf("expected(%d) = %v, want %v", status, got, want)
+		}
+	}
+}
+
+func TestFollowLimitSet(t *testing.T) {
+	tests := []struct {
+		in   string
+		want followLimit
+	}{
+		{"true", defaultFollow},
+		{"false", 0},
+		{"0", 0},
+		{"3", 3},
+	}
+	for _, tt := range tests {
+		var f followLimit
+		if err := f.Set(tt.in); err != nil || f != tt.want {
+			t.Errorf("Set(%q) = %d, %v, want %d", tt.in, f, err, tt.want)
+		}
+	}
+	for _, in := range []string{"-1", "many", ""} {
+		var f followLimit
+		if err := f.Set(in); err == nil {
+			t.Errorf("Set(%q) succeeded, want an error", in)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// keptConn is the connection --keepalive reuses for the requests to one
+// target.
+type keptConn struct {
+	*httpConn
+	lastUsed time.Time
+	requests int
+}
+
+// fetchKept sends r over the connection kept for t, opening a new one if
+// there is none yet or the server closed it in the meantime. Only the
+// response time counts as the RTT of a request on a reused connection.
+func (p *httpProber) fetchKept(t *target, r httpRequest, deadline time.Time) (*httpHop, error) {
+	r.close = false
+
+	var note string
+	p.mu.Lock()
+	c := p.kept[t]
+	delete(p.kept, t)
+	p.mu.Unlock()
+	if c != nil {
+		if !deadline.IsZero() {
+			c.conn.SetDeadline(deadline)
+		}
+		idle := time.Since(c.lastUsed).Round(time.Millisecond)
+		content, resp, response, err := c.exchange(r)
+		if err == nil {
+			c.requests++
+			hop := &httpHop{elapsed: response, detail: fmt.Sprintf("reused connection, request %d", c.requests)}
+			hop.finish(resp, content, response)
+			p.keep(t, c, resp, content)
+			return hop, nil
+		}
+		c.conn.Close()
+
+		// A connection silently dropped by a middlebox only shows as a
+		// timeout, there is no time left to retry then.
+		var nerr net.Error
+		if errors.As(err, &nerr) && nerr.Timeout() {
+			return &httpHop{elapsed: response, detail: fmt.Sprintf("reused connection idle for %s", idle)}, err
+		}
+		note = fmt.Sprintf("connection closed after %s idle and %d requests, ", idle, c.requests)
+	}
+
+	conn, hop, err := p.connect(r, deadline)
+	hop.detail = note + "new connection, " + hop.detail
+	if err != nil {
+		return hop, err
+	}
+	content, resp, response, err := conn.exchange(r)
+	hop.elapsed += response
+	if err != nil {
+		conn.conn.Close()
+		return hop, err
+	}
+	hop.finish(resp, content, response)
+	p.keep(t, &keptConn{httpConn: conn, requests: 1}, resp, content)
+	return hop, nil
+}
+
+// keep holds on to c for the next request unless the server asked to close
+// it or the body was not read completely.
+func (p *httpProber) keep(t *target, c *keptConn, resp *http.Response, content []byte) {
+	if resp.Close || len(content) >= maxBodySize {
+		c.conn.Close()
+		return
+	}
+	c.lastUsed = time.Now()
+	p.mu.Lock()
+	p.kept[t] = c
+	p.mu.Unlock()
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// startKeyControls reads single key presses from the terminal and passes
+// them to handle. It returns a function restoring the terminal, which must be
+// called before exiting. When stdin is not a terminal it does nothing.
+func startKeyControls(handle func(key byte)) func() {
+	restore, err := makeCbreak(int(os.Stdin.Fd()))
+	if err != nil {
+		return func() {}
+	}
+
+	fmt.Println("Press s for statistics, p to pause/resume, r to reset, q to quit.")
+	go func() {
+		buf := make([]byte, 1)
+		for {
+			if _, err := os.Stdin.Read(buf); err != nil {
+				return
+			}
+			handle(buf[0])
+		}
+	}()
+	return restore
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// knockTimeout bounds how long a TCP knock waits for its connect, which
+// normally never completes on a knock port.
+const knockTimeout = 100 * time.Millisecond
+
+// knock is a single port of a --knock sequence.
+type knock struct {
+	port    string
+	network string // "tcp" or "udp"
+}
+
+func (k knock) String() string {
+	return k.port + "/" + k.network
+}
+
+// knocker sends the --knock sequence to a target.
+type knocker struct {
+	sequence []knock
+	delay    time.Duration
+}
+
+// parseKnock parses a --knock value such as "7000,8000:udp,9000".
+func parseKnock(s string, delay time.Duration) (*knocker, error) {
+	k := &knocker{delay: delay}
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		kn := knock{port: part, network: "tcp"}
+		if i := strings.Index(part, ":"); i >= 0 {
+			kn.port, kn.network = part[:i], part[i+1:]
+		}
+		if n, err := strconv.Atoi(kn.port); err != nil || n < 1 || n > 65535 {
+			return nil, fmt.Errorf("invalid knock port %q", part)
+		}
+		if kn.network != "tcp" && kn.network != "udp" {
+			return nil, fmt.Errorf("invalid knock protocol %q, expected tcp or udp", kn.network)
+		}
+		k.sequence = append(k.sequence, kn)
+	}
+	return k, nil
+}
+
+func (k *knocker) String() string {
+	ports := make([]string, len(k.sequence))
+	for i, kn := range k.sequence {
+		ports[i] = kn.String()
+	}
+	return strings.Join(ports, ", ")
+}
+
+// knock sends the sequence to t, waiting the delay after every knock. TCP
+// knocks are a connect attempt, UDP knocks an empty datagram; whether they
+// are answered does not matter.
+func (k *knocker) knock(t *target) {
+	for _, kn := range k.sequence {
+		address := t.address + ":" + kn.port
+		if kn.network == "udp" {
+			if conn, err := net.Dial("udp", address); err == nil {
+				conn.Write(nil)
+				conn.Close()
+			}
+		} else if conn, err := net.DialTimeout("tcp", address, knockTimeout); err == nil {
+			conn.Close()
+		}
+		time.Sleep(k.delay)
+	}
+}
+
+// wrap returns a probeFunc that knocks before every probe.
+func (k *knocker) wrap(probe probeFunc) probeFunc {
+	return func(t *target, timeout time.Duration) (time.Duration, string, error) {
+		k.knock(t)
+		return probe(t, timeout)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// runLoad measures t with a single worker for the baseline and then with
+// the given number of concurrent workers, each pinging once per interval,
+// for duration each. It reports how latency and loss changed under load. The
+// loaded results are kept in t.stats for --summary-file.
+func runLoad(t *target, probe probeFunc, workers int, duration, interval, timeout time.Duration) {
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(interrupt)
+
+	fmt.Printf("Measuring the baseline of %s with 1 worker for %s...\n", t, duration)
+	baseline, ok := loadPhase(t, probe, 1, duration, interval, timeout, interrupt)
+	var loaded statistics
+	if ok {
+		fmt.Printf("Loading %s with %d workers for %s...\n", t, workers, duration)
+		loaded, _ = loadPhase(t, probe, workers, duration, interval, timeout, interrupt)
+	}
+	t.stats = loaded
+
+	printLoadStatistics(t, workers, baseline, loaded)
+}
+
+// loadPhase runs the workers for duration, or until interrupted, in which
+// case it returns false. The workers start spread over the interval so the
+// pings do not arrive in bursts.
+func loadPhase(t *target, probe probeFunc, workers int, duration, interval, timeout time.Duration, interrupt <-chan os.Signal) (statistics, bool) {
+	var mu sync.Mutex
+	var s statistics
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+
+	start := time.Now()
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		// Every worker has its own copy of the target, probes keep per
+		// target state.
+		wt := *t
+		offset := interval * time.Duration(i) / time.Duration(workers)
+		go func() {
+			defer wg.Done()
+			next := start.Add(offset)
+			for {
+				select {
+				case <-stop:
+					return
+				case <-time.After(time.Until(next)):
+				}
+				elapsed, _, err := probe(&wt, timeout)
+				mu.Lock()
+				s.add(elapsed, err)
+				mu.Unlock()
+				next = next.Add(interval)
+				if now := time.Now(); next.Before(now) {
+					next = now
+				}
+			}
+		}()
+	}
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	deadline := time.After(duration)
+
+	ok := true
+	var last statistics
+loop:
+	for {
+		select {
+		case <-ticker.C:
+			mu.Lock()
+			current := s
+			mu.Unlock()
+			sent := current.sentCount - last.sentCount
+			failed := sent - (current.respondedCount - last.respondedCount)
+			line := fmt.Sprintf("[%s] %d pings/s, %d failed", time.Since(start).Round(time.Second), sent, failed)
+			if responded := current.respondedCount - last.respondedCount; responded > 0 {
+				avg := (current.totalResponseTime - last.totalResponseTime) / time.Duration(responded)
+				line += ", avg " + formatRTT(avg)
+			}
+			fmt.Println(line)
+			last = current
+		case <-deadline:
+			break loop
+		case <-interrupt:
+			fmt.Println("\nLoad test interrupted.")
+			ok = false
+			break loop
+		}
+	}
+	close(stop)
+	wg.Wait()
+	return s, ok
+}
+
+func printLoadStatistics(t *target, workers int, baseline, loaded statistics) {
+	fmt.Println("")
+	fmt.Printf("--- Load Statistics for %s ---\n", t)
+	fmt.Printf("%-8s %12s %12s %12s\n", "", "baseline", fmt.Sprintf("%d workers", workers), "change")
+	fmt.Printf("%-8s %12d %12d\n", "pings", baseline.sentCount, loaded.sentCount)
+	if baseline.sentCount > 0 && loaded.sentCount > 0 {
+		fmt.Printf("%-8s %11.2f%% %11.2f%% %+11.2f%%\n", "loss", baseline.loss(), loaded.loss(), loaded.loss()-baseline.loss())
+	}
+	if baseline.respondedCount == 0 || loaded.respondedCount == 0 {
+		fmt.Println("Not enough responses to compare latencies.")
+		return
+	}
+
+	rows := []struct {
+		name   string
+		values func(s *statistics) time.Duration
+	}{
+		{"min", func(s *statistics) time.Duration { return s.minTime }},
+		{"avg", func(s *statistics) time.Duration { return s.avg() }},
+		{"p50", func(s *statistics) time.Duration { return s.median() }},
+		{"p95", func(s *statistics) time.Duration { return s.percentile(95) }},
+		{"p99", func(s *statistics) time.Duration { return s.percentile(99) }},
+		{"max", func(s *statistics) time.Duration { return s.maxTime }},
+	}
+	for _, row := range rows {
+		b, l := row.values(&baseline), row.values(&loaded)
+		fmt.Printf("%-8s %12s %12s %12s\n", row.name, formatRTT(b), formatRTT(l), formatRTTDelta(l-b))
+	}
+}
@@ -3,10 +3,13 @@ package main
 import (
 	"flag"
 	"fmt"
+	"math/rand"
 	"net"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 )
@@ -14,10 +17,113 @@ import (
 var stopPing chan bool
 
 func main() {
+	// Subcommands other than agent, which pings like the default mode, have
+	// their own flags.
+	var agentMode bool
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "self-update":
+			os.Exit(runSelfUpdate(os.Args[2:]))
+		case "collector":
+			os.Exit(runCollector(os.Args[2:]))
+		case "agent":
+			agentMode = true
+			os.Args = append(os.Args[:1], os.Args[2:]...)
+		}
+	}
+
 	ipv4Flag := flag.Bool("4", false, "Ping IPv4 address")
 	ipv6Flag := flag.Bool("6", false, "Ping IPv6 address")
 	countFlag := flag.Int("n", 0, "Number of pings (default: infinite)")
 	timeoutFlag := flag.Int("t", 1, "Time interval between pings in seconds")
+	nagiosFlag := flag.Bool("nagios", false, "Run as a Nagios/Icinga plugin")
+	warningFlag := flag.String("w", "100,20%", "Nagios warning threshold as rta,pl%")
+	criticalFlag := flag.String("c", "500,60%", "Nagios critical threshold as rta,pl%")
+	zabbixFlag := flag.String("zabbix", "", "Send results to a Zabbix server at host[:port]")
+	zabbixHostFlag := flag.String("zabbix-host", "", "Host name the results are reported under in Zabbix")
+	compareFlag := flag.Bool("compare", false, "Compare two hosts side by side: hostA hostB port, or hostA portA hostB portB")
+	warmupFlag := flag.Int("warmup", 0, "Number of initial pings shown but excluded from the statistics")
+	burstFlag := flag.Int("burst", 1, "Number of back-to-back pings sent to each target every interval")
+	retriesFlag := flag.Int("retries", 0, "Number of times a failed ping is retried before it counts as lost")
+	retryBackoffFlag := flag.Duration("retry-backoff", 200*time.Millisecond, "Wait before the first retry, doubled for every further retry")
+	jitterFlag := flag.String("interval-jitter", "", "Randomize each wait by up to this percentage of the interval, e.g. 20%")
+	maxRateFlag := flag.Float64("max-rate", 0, "Maximum number of pings per second across all targets (default: unlimited)")
+	movingAvgFlag := flag.Int("moving-avg", 0, "Show the moving average of the last N RTTs on every line")
+	deltaFlag := flag.Bool("delta", false, "Show the difference from the previous RTT with a trend marker on every line")
+	aggregateFlag := flag.Duration("aggregate", 0, "Also print per-target loss and latency aggregated over this period, e.g. 1m")
+	aggregateFileFlag := flag.String("aggregate-file", "", "Append the --aggregate buckets to this CSV file")
+	slaFlag := flag.Float64("sla", 0, "Target availability in percent, e.g. 99.9; exit non-zero if it was missed")
+	watchFlag := flag.Bool("watch", false, "Redraw a compact status screen every interval instead of scrolling output")
+	onelineFlag := flag.Bool("oneline", false, "Keep updating a single status line in place instead of scrolling output")
+	progressFlag := flag.Bool("progress", false, "Show a progress bar with the estimated time remaining when -n is set")
+	dedupFlag := flag.Bool("dedup", false, "Collapse runs of identical results into a single line, printing full lines only on changes")
+	unitFlag := flag.String("unit", "ms", "Unit RTTs are printed in: us, ms or s")
+	decimalsFlag := flag.Int("decimals", 0, "Number of decimal places RTTs are printed with")
+	scheduleFlag := flag.String("schedule", "", "Only ping at the times of this crontab expression, e.g. '*/5 * * * *'")
+	scheduleCountFlag := flag.Int("schedule-count", 1, "Number of rounds of pings every --schedule activation, -t apart")
+	strictIntervalFlag := flag.Bool("strict-interval", false, "Schedule pings relative to the start of the previous one, so slow pings do not stretch the interval")
+	arpFlag := flag.Bool("arp", false, "Resolve the link-layer address of on-link targets with ARP or NDP before every ping and time it (Linux, needs root)")
+	probeFlag := flag.String("probe", "", "Kind of probe to send: "+strings.Join(proberNames(), ", ")+" (default: tcp)")
+	synFlag := flag.Bool("syn", false, "Only send a SYN and time the SYN-ACK without completing the handshake (Linux, needs root)")
+	tlsFlag := flag.Bool("tls", false, "Complete a TLS handshake after connecting and time it")
+	sniFlag := flag.String("sni", "", "Server name sent in the TLS handshake (default: the address given)")
+	tlsMinFlag := flag.String("tls-min", "", "Minimum TLS version to offer: 1.0, 1.1, 1.2 or 1.3")
+	tlsMaxFlag := flag.String("tls-max", "", "Maximum TLS version to offer: 1.0, 1.1, 1.2 or 1.3")
+	ciphersFlag := flag.String("ciphers", "", "Comma separated TLS 1.0-1.2 cipher suites to offer, e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256")
+	certFlag := flag.String("cert", "", "Client certificate (PEM) presented in the TLS handshake")
+	keyFlag := flag.String("key", "", "Private key (PEM) for --cert")
+	caFileFlag := flag.String("ca-file", "", "Verify server certificates against the CA certificates (PEM) in this file instead of the system roots")
+	alpnFlag := flag.String("alpn", "", "Comma separated protocols offered via ALPN, e.g. h2,http/1.1")
+	ocspFlag := flag.Bool("ocsp", false, "Check the revocation status of the server certificate from the stapled OCSP response or the OCSP responder")
+	insecureFlag := flag.Bool("insecure", false, "Do not fail the handshake on an invalid server certificate, report the verification result instead")
+	httpFlag := flag.Bool("http", false, "Send an HTTP request after connecting (HTTPS with --tls) and time the response")
+	pathFlag := flag.String("path", "/", "Path requested in --http mode")
+	expectStatusFlag := flag.String("expect-status", "200-399", "Status codes counted as success in --http mode, e.g. 200-299 or 200,301-302")
+	expectBodyFlag := flag.String("expect-body", "", "Regular expression the response body must match in --http mode")
+	methodFlag := flag.String("X", "", "Request method in --http mode (default: GET, or POST with --data)")
+	var headersFlag headerFlags
+	flag.Var(&headersFlag, "H", "Request header in --http mode, e.g. 'Authorization: Bearer ...'; may be repeated")
+	var followFlag followLimit
+	flag.Var(&followFlag, "follow", fmt.Sprintf("Follow redirects in --http mode, up to %d or --follow=N", defaultFollow))
+	keepaliveFlag := flag.Bool("keepalive", false, "Reuse one connection per target for all requests in --http mode")
+	dataFlag := flag.String("data", "", "Request body in --http mode, or @file to read it from a file")
+	knockFlag := flag.String("knock", "", "Port knocking sequence sent before every ping, e.g. 7000,8000,9000:udp")
+	knockDelayFlag := flag.Duration("knock-delay", 200*time.Millisecond, "Wait between the knocks of --knock")
+	knockOnceFlag := flag.Bool("knock-once", false, "Send the --knock sequence only once at startup")
+	successIfFlag := flag.String("success-if", "", "Condition deciding whether a ping succeeded, e.g. 'rtt<100ms && banner=~\"SSH-2.0\"'")
+	benchFlag := flag.Bool("bench", false, "Open connections as fast as possible and report the connection rate")
+	loadFlag := flag.Int("load", 0, "Compare latency and loss under N concurrent ping workers with a single worker")
+	durationFlag := flag.Duration("duration", 10*time.Second, "How long --bench runs, or each phase of --load")
+	concurrencyFlag := flag.Int("concurrency", 10, "Number of parallel connections in --bench mode")
+	downAfterFlag := flag.Int("down-after", 1, "Number of consecutive failed pings before a target counts as down, for outages and alerts")
+	upAfterFlag := flag.Int("up-after", 1, "Number of consecutive successful pings before a target counts as up again")
+	slackFlag := flag.String("slack-webhook", "", "Post alerts to this Slack incoming webhook URL")
+	telegramFlag := flag.String("telegram", "", "Send alerts from a Telegram bot, as bot-token:chat-id")
+	smtpFlag := flag.String("smtp", "", "Mail alerts via this server, smtp://[user:password@]host[:port] or smtps://...")
+	mailFromFlag := flag.String("mail-from", "", "Sender address of --smtp alerts (default: tcping@hostname)")
+	mailToFlag := flag.String("mail-to", "", "Comma separated recipients of --smtp alerts")
+	alertRTTFlag := flag.Duration("alert-rtt", 0, "Also alert when the RTT of a target rises above this, e.g. 200ms")
+	alertIntervalFlag := flag.Duration("alert-interval", 5*time.Minute, "Minimum time between two alerts for the same target")
+	wolFlag := flag.String("wol", "", "Send a Wake-on-LAN packet to MAC[,broadcast] first and ping until the target is ready")
+	joinFlag := flag.String("join", "", "Collector at host[:port] tcping agent streams its results to")
+	agentNameFlag := flag.String("agent-name", "", "Name the results of tcping agent are reported under (default: the host name)")
+	stateFileFlag := flag.String("state-file", "", "Resume the statistics from this file and checkpoint them to it periodically and on exit")
+	stateIntervalFlag := flag.Duration("state-interval", time.Minute, "How often --state-file is checkpointed")
+	summaryFileFlag := flag.String("summary-file", "", "Write the final statistics as JSON to this file on exit")
+	chartFlag := flag.String("chart", "", "Draw the RTTs and lost pings over time into this .png or .svg file on exit")
+	timeDNSFlag := flag.Bool("time-dns", false, "Resolve the address before every ping and time the lookup separately")
+	rdnsFlag := flag.Bool("rdns", false, "Show the reverse DNS name of each target")
+	whoisFlag := flag.Bool("whois", false, "Show the RDAP/WHOIS owner of each target")
+	geoFlag := flag.Bool("geo", false, "Show the location and AS number of each target")
+	compareFamilyFlag := flag.Bool("compare-family", false, "Compare the IPv4 and IPv6 addresses of a single target side by side")
+	fallbackFamilyFlag := flag.Int("fallback-family", 0, "Switch a target to its address in the other family after N failed pings in a row, and back the same way")
+	flag.Usage = func() {
+		fmt.Println("Usage: tcping [options] address port [address port ...]")
+		fmt.Println("       tcping agent --join collector[:port] [options] address port [address port ...]")
+		fmt.Println("       tcping collector [--listen :7000] [--http :8080]")
+		fmt.Println("       tcping self-update [--check-only] [--force]")
+		flag.PrintDefaults()
+	}
 	flag.Parse()
 
 	if *ipv4Flag && *ipv6Flag {
@@ -25,64 +131,721 @@ func main() {
 		os.Exit(1)
 	}
 
-	args := flag.Args()
-	if len(args) < 2 {
-		fmt.Println("Usage: tcping [-4] [-6] [-n count] [-t timeout] address port")
+	if err := setRTTFormat(*unitFlag, *decimalsFlag); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	jitter, err := parseJitter(*jitterFlag)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	if *maxRateFlag < 0 {
+		fmt.Println("--max-rate cannot be negative.")
+		os.Exit(1)
+	} else if *maxRateFlag > 0 {
+		rateLimiter = newTokenBucket(*maxRateFlag)
+	}
+
+	if *movingAvgFlag < 0 {
+		fmt.Println("--moving-avg cannot be negative.")
+		os.Exit(1)
+	}
+
+	if (*watchFlag || *onelineFlag) && (*compareFlag || *compareFamilyFlag) {
+		fmt.Println("--watch and --oneline cannot be combined with --compare or --compare-family.")
+		os.Exit(1)
+	}
+	if *watchFlag && *onelineFlag {
+		fmt.Println("Both --watch and --oneline flags cannot be used together.")
+		os.Exit(1)
+	}
+
+	if *progressFlag && (*countFlag <= 0 || *onelineFlag || *compareFlag || *compareFamilyFlag) {
+		fmt.Println("--progress requires -n and cannot be combined with --oneline, --compare or --compare-family.")
+		os.Exit(1)
+	}
+
+	if *dedupFlag && (*watchFlag || *onelineFlag || *compareFlag || *compareFamilyFlag) {
+		fmt.Println("--dedup cannot be combined with --watch, --oneline, --compare or --compare-family.")
 		os.Exit(1)
 	}
 
-	address := args[0]
-	port := args[1]
+	if *chartFlag != "" && (*nagiosFlag || *benchFlag || *loadFlag > 0 || *compareFlag || *compareFamilyFlag) {
+		fmt.Println("--chart cannot be combined with --nagios, --bench, --load, --compare or --compare-family.")
+		os.Exit(1)
+	}
 
-	if *ipv4Flag || (!*ipv6Flag && isIPv4(address)) {
-		address = resolveAddress(address, "ipv4")
-	} else if *ipv6Flag || isIPv6(address) {
-		address = resolveAddress(address, "ipv6")
+	if *fallbackFamilyFlag < 0 {
+		fmt.Println("--fallback-family cannot be negative.")
+		os.Exit(1)
+	}
+	if *fallbackFamilyFlag > 0 && (*nagiosFlag || *benchFlag || *loadFlag > 0 || *compareFlag || *compareFamilyFlag) {
+		fmt.Println("--fallback-family cannot be combined with --nagios, --bench, --load, --compare or --compare-family.")
+		os.Exit(1)
+	}
+
+	var schedule *cronSchedule
+	if *scheduleFlag != "" {
+		if schedule, err = parseCron(*scheduleFlag); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		if schedule.next(time.Now()).IsZero() {
+			fmt.Printf("--schedule %q never matches.\n", *scheduleFlag)
+			os.Exit(1)
+		}
+		if *scheduleCountFlag < 1 {
+			fmt.Println("--schedule-count must be at least 1.")
+			os.Exit(1)
+		}
+	} else if *scheduleCountFlag != 1 {
+		fmt.Println("--schedule-count requires --schedule.")
+		os.Exit(1)
+	}
+
+	if *downAfterFlag < 1 || *upAfterFlag < 1 {
+		fmt.Println("--down-after and --up-after must be at least 1.")
+		os.Exit(1)
+	}
+	downAfter, upAfter = *downAfterFlag, *upAfterFlag
+
+	if *burstFlag < 1 {
+		fmt.Println("--burst must be at least 1.")
+		os.Exit(1)
+	}
+
+	args := flag.Args()
+	if *compareFlag {
+		if len(args) == 3 {
+			args = []string{args[0], args[2], args[1], args[2]}
+		}
+		if len(args) != 4 {
+			flag.Usage()
+			os.Exit(1)
+		}
+	}
+	if len(args) < 2 || len(args)%2 != 0 {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	var targets []*target
+	if *compareFamilyFlag {
+		if *ipv4Flag || *ipv6Flag || *compareFlag || len(args) != 2 {
+			fmt.Println("--compare-family takes a single address and port and cannot be combined with -4, -6 or --compare.")
+			os.Exit(1)
+		}
+		targets, err = resolveFamilies(args[0], args[1])
 	} else {
-		// Default to IPv4 if no -4 or -6 flags specified and address is not explicitly IPv6
-		address = resolveAddress(address, "ipv4")
+		targets, err = resolveTargets(args, *ipv4Flag, *ipv6Flag)
+	}
+
+	if err == nil && (*benchFlag || *loadFlag > 0) && (*nagiosFlag || *compareFlag || *compareFamilyFlag || (*benchFlag && *loadFlag > 0)) {
+		err = fmt.Errorf("--bench and --load cannot be combined with each other, --nagios or --compare")
+	}
+	if err == nil && *nagiosFlag && len(targets) > 1 {
+		err = fmt.Errorf("--nagios checks a single target")
+	}
+	if *benchFlag {
+		if err == nil && len(targets) > 1 {
+			err = fmt.Errorf("--bench takes a single target")
+		}
+		if err == nil && (*concurrencyFlag < 1 || *durationFlag <= 0) {
+			err = fmt.Errorf("--concurrency and --duration must be positive")
+		}
+	} else if err == nil && (*concurrencyFlag != 10 || (*durationFlag != 10*time.Second && *loadFlag == 0)) {
+		err = fmt.Errorf("--concurrency requires --bench, --duration requires --bench or --load.")
+	}
+
+	// exit reports a problem with the command line and quits, with an UNKNOWN
+	// status line for --nagios.
+	exit := func(msg interface{}) {
+		if *nagiosFlag {
+			fmt.Printf("TCPING UNKNOWN - %v\n", msg)
+			os.Exit(nagiosUnknown)
+		}
+		fmt.Println(msg)
+		os.Exit(1)
+	}
+	if err != nil {
+		exit(err)
+	}
+
+	timeout := time.Duration(*timeoutFlag) * time.Second
+	if *probeFlag != "" && (*synFlag || *tlsFlag || *httpFlag) {
+		exit("--probe cannot be combined with --syn, --tls or --http.")
+	}
+	if *synFlag && (*tlsFlag || *httpFlag) {
+		exit("--syn cannot be combined with --tls or --http.")
+	}
+	name := *probeFlag
+	switch {
+	case *httpFlag:
+		name = "http"
+	case *tlsFlag:
+		name = "tls"
+	case *synFlag:
+		name = "syn"
+	case name == "":
+		name = "tcp"
+	}
+
+	cfg := &proberConfig{
+		tls: *tlsFlag,
+		tlsOpts: tlsOptions{
+			sni:      *sniFlag,
+			min:      *tlsMinFlag,
+			max:      *tlsMaxFlag,
+			ciphers:  *ciphersFlag,
+			cert:     *certFlag,
+			key:      *keyFlag,
+			caFile:   *caFileFlag,
+			insecure: *insecureFlag,
+			alpn:     *alpnFlag,
+			ocsp:     *ocspFlag,
+		},
+		http: httpOptions{
+			path:         *pathFlag,
+			expectStatus: *expectStatusFlag,
+			expectBody:   *expectBodyFlag,
+			method:       *methodFlag,
+			headers:      headersFlag,
+			data:         *dataFlag,
+			follow:       int(followFlag),
+			keepalive:    *keepaliveFlag,
+		},
+	}
+	if cfg.tlsOpts != (tlsOptions{}) && name != "tls" && !cfg.tls {
+		exit("The TLS options (--sni, --tls-min, --cert, --ocsp, ...) require --tls.")
+	}
+	if name != "http" && (*pathFlag != "/" || *expectStatusFlag != "200-399" || *expectBodyFlag != "" || *methodFlag != "" || len(headersFlag) > 0 || *dataFlag != "" || followFlag != 0 || *keepaliveFlag) {
+		exit("The HTTP options (--path, --expect-status, -X, -H, --data, --follow, --keepalive, ...) require --http.")
+	}
+
+	// With --success-if, plain TCP pings read the server's greeting if the
+	// condition looks at the banner.
+	var cond condition
+	if *successIfFlag != "" {
+		var err error
+		if cond, err = parseCondition(*successIfFlag); err != nil {
+			exit(err)
+		}
+		if usesBanner(cond) && name != "tcp" && name != "banner" && name != "http" {
+			exit("banner in --success-if is only available for plain TCP and --http pings.")
+		}
+		if usesBanner(cond) && name == "tcp" {
+			name = "banner"
+		}
+	}
+
+	prober, err := newProber(name, cfg)
+	if err != nil && name == "syn" {
+		fmt.Printf("%v, falling back to a full connect.\n", err)
+		prober, err = newProber("tcp", cfg)
+	}
+	if err != nil {
+		exit(err)
+	}
+	probe := proberFunc(prober)
+
+	if *arpFlag {
+		arpProbe, err := newARPProbe(targets, probe)
+		if err != nil {
+			exit(err)
+		}
+		probe = arpProbe
+	}
+
+	if *knockFlag != "" {
+		knocker, err := parseKnock(*knockFlag, *knockDelayFlag)
+		if err != nil {
+			exit(err)
+		}
+		if *knockOnceFlag {
+			for _, t := range targets {
+				knocker.knock(t)
+				fmt.Printf("Knocked %s on %s\n", knocker, t.address)
+			}
+		} else {
+			probe = knocker.wrap(probe)
+		}
+	} else if *knockOnceFlag {
+		exit("--knock-once requires --knock.")
+	}
+
+	if cond != nil {
+		probe = successProbe(cond, probe)
+	}
+
+	if *nagiosFlag {
+		start := time.Now()
+		status := runNagios(targets[0], probe, *countFlag, timeout, *warningFlag, *criticalFlag)
+		if *summaryFileFlag != "" {
+			if err := writeSummaryFile(*summaryFileFlag, targets, start); err != nil {
+				fmt.Printf("TCPING UNKNOWN - Failed to write summary file: %v\n", err)
+				os.Exit(nagiosUnknown)
+			}
+		}
+		os.Exit(status)
+	}
+
+	if *benchFlag {
+		start := time.Now()
+		runBench(targets[0], probe, *durationFlag, *concurrencyFlag, timeout)
+		if *summaryFileFlag != "" {
+			if err := writeSummaryFile(*summaryFileFlag, targets, start); err != nil {
+				fmt.Printf("Failed to write summary file: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		os.Exit(0)
+	}
+
+	var zabbix *zabbixSender
+	if *zabbixFlag != "" {
+		zabbix, err = newZabbixSender(*zabbixFlag, *zabbixHostFlag, len(targets) > 1)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+
+	var agent *agentStream
+	if agentMode {
+		if *joinFlag == "" {
+			fmt.Println("tcping agent requires --join.")
+			os.Exit(1)
+		}
+		if agent, err = newAgentStream(*joinFlag, *agentNameFlag); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	} else if *joinFlag != "" || *agentNameFlag != "" {
+		fmt.Println("--join and --agent-name require tcping agent.")
+		os.Exit(1)
+	}
+
+	alerts, err := newAlerter(alertOptions{
+		slackWebhook: *slackFlag,
+		telegram:     *telegramFlag,
+		smtp:         *smtpFlag,
+		mailFrom:     *mailFromFlag,
+		mailTo:       *mailToFlag,
+		rtt:          *alertRTTFlag,
+		interval:     *alertIntervalFlag,
+	})
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	var fallback *familyFallback
+	if *fallbackFamilyFlag > 0 {
+		fallback = newFamilyFallback(targets, *fallbackFamilyFlag)
+	}
+
+	var chart *chartRecorder
+	if *chartFlag != "" {
+		if chart, err = newChartRecorder(*chartFlag); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+
+	var agg *aggregator
+	if *aggregateFlag != 0 {
+		agg, err = newAggregator(*aggregateFlag, *aggregateFileFlag)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+
+	for _, t := range targets {
+		if *movingAvgFlag > 0 {
+			t.movingAvg = newMovingAverage(*movingAvgFlag)
+		}
+		if *rdnsFlag {
+			t.hostname = reverseLookup(t.address)
+		}
+		if t.hostname != "" {
+			fmt.Printf("Pinging %s (%s)...\n", t, t.hostname)
+		} else {
+			fmt.Printf("Pinging %s...\n", t)
+		}
+		if *geoFlag {
+			if geo, err := lookupGeo(t.address); err != nil {
+				fmt.Printf("GeoIP lookup for %s failed: %v\n", t.address, err)
+			} else {
+				t.geo = geo
+				fmt.Printf("GeoIP for %s: %s\n", t.address, geo)
+			}
+		}
+		if *whoisFlag {
+			if network, err := lookupRDAP(t.address); err != nil {
+				fmt.Printf("RDAP lookup for %s failed: %v\n", t.address, err)
+			} else {
+				fmt.Printf("RDAP for %s: %s\n", t.address, network)
+			}
+		}
+	}
+
+	if *stateFileFlag != "" {
+		if *stateIntervalFlag <= 0 {
+			fmt.Println("--state-interval must be positive.")
+			os.Exit(1)
+		}
+		if err := loadStateFile(*stateFileFlag, targets); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+
+	if *loadFlag > 0 {
+		if len(targets) > 1 {
+			fmt.Println("--load takes a single target")
+			os.Exit(1)
+		}
+		if *durationFlag <= 0 || timeout <= 0 {
+			fmt.Println("--duration and -t must be positive")
+			os.Exit(1)
+		}
+		start := time.Now()
+		runLoad(targets[0], probe, *loadFlag, *durationFlag, timeout, timeout)
+		if *summaryFileFlag != "" {
+			if err := writeSummaryFile(*summaryFileFlag, targets, start); err != nil {
+				fmt.Printf("Failed to write summary file: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		os.Exit(0)
+	}
+
+	// With --wol the target is pinged until it answers for the first time,
+	// readyAt is when it did.
+	var wake *wakeOnLAN
+	var wokeAt, readyAt time.Time
+	if *wolFlag != "" {
+		if len(targets) > 1 {
+			fmt.Println("--wol takes a single target")
+			os.Exit(1)
+		}
+		var err error
+		if wake, err = parseWakeOnLAN(*wolFlag); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+
+	// printResult shows the outcome of a single ping and afterRound runs once
+	// every target has been pinged. printNote shows the other lines about a
+	// target that come up while pinging, such as the --aggregate summaries.
+	// Display modes such as --watch replace them.
+	printResult := func(t *target, line string, elapsed time.Duration, err error) {
+		fmt.Println(line)
+	}
+	printNote := func(t *target, line string) {
+		fmt.Println(line)
+	}
+	afterRound := func() {}
+
+	// record accounts a ping result everywhere except warmup pings, which are
+	// only printed.
+	record := func(t *target, elapsed time.Duration, err error, sentAt time.Time) {
+		t.stats.add(elapsed, err)
+		t.outages.record(sentAt, err == nil)
+		if fallback != nil {
+			fallback.record(t, elapsed, err)
+		}
+		if wake != nil && err == nil && readyAt.IsZero() {
+			readyAt = sentAt.Add(elapsed)
+		}
+
+		if agg != nil {
+			agg.add(t, elapsed, err)
+		}
+
+		if chart != nil {
+			chart.add(t, sentAt, elapsed, err)
+		}
+
+		if zabbix != nil {
+			zabbix.sendProbe(t, elapsed, err)
+		}
+
+		if alerts != nil {
+			alerts.record(t, elapsed, err, sentAt)
+		}
+
+		if agent != nil {
+			agent.send(t, elapsed, err, sentAt)
+		}
+
+	}
+	probeTarget := func(t *target, warmup bool) (time.Duration, error) {
+		var suffix string
+		if warmup {
+			suffix = " (warmup)"
+		}
+		sentAt := time.Now()
+
+		if *timeDNSFlag {
+			lookup, err := t.resolve()
+			if !warmup {
+				t.dns.add(lookup, err)
+			}
+			if err != nil {
+				printResult(t, fmt.Sprintf("%v%s", err, suffix), 0, err)
+				if !warmup {
+					record(t, 0, err, sentAt)
+				}
+				return 0, err
+			}
+			suffix = " (dns " + formatRTT(lookup) + ")" + suffix
+		}
+
+		elapsed, detail, attempt, err := probeWithRetries(probe, t, timeout, *retriesFlag, *retryBackoffFlag)
+		if *retriesFlag > 0 && attempt > 1 {
+			suffix = fmt.Sprintf(" (attempt %d)", attempt) + suffix
+		}
+		if detail != "" {
+			suffix = " (" + detail + ")" + suffix
+		}
+
+		if _, ok := err.(*mismatchError); ok {
+			printResult(t, fmt.Sprintf("Unexpected response from %s: %v%s", t, err, suffix), elapsed, err)
+		} else if err != nil {
+			printResult(t, fmt.Sprintf("Failed to connect to %s: %v%s", t, err, suffix), elapsed, err)
+		} else {
+			if t.movingAvg != nil && !warmup {
+				suffix = " (mavg " + formatRTT(t.movingAvg.add(elapsed)) + ")" + suffix
+			}
+			if *deltaFlag && !warmup {
+				if t.responded {
+					suffix = " (" + formatDelta(elapsed-t.lastTime) + ")" + suffix
+				}
+				t.lastTime, t.responded = elapsed, true
+			}
+			printResult(t, fmt.Sprintf("tcping %s in %s%s", t, formatRTT(elapsed), suffix), elapsed, err)
+		}
+
+		if !warmup {
+			record(t, elapsed, err, sentAt)
+		}
+		return elapsed, err
+	}
+	probeRound := func(warmup bool) {
+		for _, t := range targets {
+			var burst statistics
+			for i := 0; i < *burstFlag; i++ {
+				burst.add(probeTarget(t, warmup))
+			}
+			if *burstFlag > 1 {
+				printBurst(t, burst)
+			}
+		}
+	}
+	printSummary := func() {
+		printStatistics(targets)
+	}
+
+	// Warmup pings come on top of the requested count.
+	rounds := *countFlag
+	if rounds != 0 {
+		rounds += *warmupFlag
+	}
+
+	// The screen of --watch and the status line of --oneline have no room
+	// for notes, they show the statistics instead.
+	if *watchFlag {
+		w := newWatchScreen(targets, timeout)
+		printResult = w.result
+		printNote = func(t *target, line string) {}
+		afterRound = w.draw
+	} else if *onelineFlag {
+		o := newOnelineStatus(targets)
+		printResult = o.result
+		printNote = func(t *target, line string) {}
+		afterRound = o.draw
+	}
+
+	var dedup *deduper
+	if *dedupFlag {
+		dedup = newDeduper(printResult, targets)
+		printResult = dedup.result
+		printNote = dedup.note
+	}
+
+	if *progressFlag {
+		p := newProgressBar(rounds, timeout)
+		show, note, done := printResult, printNote, afterRound
+		printResult = func(t *target, line string, elapsed time.Duration, err error) {
+			p.clear()
+			show(t, line, elapsed, err)
+		}
+		printNote = func(t *target, line string) {
+			p.clear()
+			note(t, line)
+		}
+		afterRound = func() {
+			done()
+			p.draw()
+		}
+	}
+
+	if agg != nil {
+		agg.show = printNote
+	}
+	if fallback != nil {
+		fallback.show = printNote
+	}
+
+	var c *comparison
+	if *compareFlag || *compareFamilyFlag {
+		c = newComparison(targets[0], targets[1], probe, timeout, record)
+		probeRound = c.round
+		printSummary = c.summary
 	}
 
-	fmt.Printf("Pinging %s:%s...\n", address, port)
 	stopPing = make(chan bool, 1)
 	interrupt := make(chan os.Signal, 1)
 	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
 
-	var sentCount int
-	var respondedCount int
-	var minTime, maxTime, totalResponseTime int64
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	start := time.Now()
+
+	// Wake the target before the key controls take over the terminal, so a
+	// failure here does not leave it in cbreak mode.
+	if wake != nil {
+		if err := wake.send(); err != nil {
+			fmt.Printf("Failed to send the Wake-on-LAN packet: %v\n", err)
+			os.Exit(1)
+		}
+		wokeAt = time.Now()
+		fmt.Printf("Sent a Wake-on-LAN packet to %s via %s.\n", wake.mac, wake.broadcast)
+	}
+
+	// mu serializes probe rounds with the key controls, which read and reset
+	// the statistics in between.
+	var mu sync.Mutex
+	var paused bool
+	restoreTerminal := startKeyControls(func(key byte) {
+		switch key {
+		case 's':
+			mu.Lock()
+			printSummary()
+			mu.Unlock()
+		case 'p':
+			mu.Lock()
+			paused = !paused
+			if paused {
+				fmt.Println("Ping paused, press p to resume.")
+			} else {
+				fmt.Println("Ping resumed.")
+			}
+			mu.Unlock()
+		case 'r':
+			mu.Lock()
+			for _, t := range targets {
+				t.reset()
+				if alerts != nil {
+					alerts.reset(t)
+				}
+				if fallback != nil {
+					fallback.reset(t)
+				}
+			}
+			if chart != nil {
+				chart.reset()
+			}
+			if c != nil {
+				c.fasterA, c.fasterB = 0, 0
+			}
+			fmt.Println("Statistics reset.")
+			mu.Unlock()
+		case 'q':
+			select {
+			case interrupt <- os.Interrupt:
+			default:
+			}
+		}
+	})
 
 	go func() {
-		for i := 0; *countFlag == 0 || i < *countFlag; i++ {
+		// next is when the following round is due with --strict-interval.
+		next := time.Now()
+		checkpoint := time.Now()
+		for i := 0; rounds == 0 || i < rounds; i++ {
 			select {
 			case <-stopPing:
 				return
 			default:
-				start := time.Now()
-				conn, err := net.DialTimeout("tcp", address+":"+port, time.Duration(*timeoutFlag)*time.Second)
-				elapsed := time.Since(start).Milliseconds()
+				// With --schedule every activation starts with a wait for
+				// the next matching minute.
+				if schedule != nil && i%*scheduleCountFlag == 0 {
+					at := schedule.next(time.Now())
+					fmt.Printf("Next scheduled run at %s.\n", at.Format("2006-01-02 15:04"))
+					time.Sleep(time.Until(at))
+					next = at
+				}
 
-				sentCount++
-				if err != nil {
-					fmt.Printf("Failed to connect to %s:%s: %v\n", address, port, err)
-				} else {
-					conn.Close()
-					respondedCount++
-					if respondedCount == 1 || elapsed < minTime {
-						minTime = elapsed
+				mu.Lock()
+				for paused {
+					mu.Unlock()
+					time.Sleep(100 * time.Millisecond)
+					mu.Lock()
+				}
+				probeRound(i < *warmupFlag)
+				afterRound()
+				// Only the snapshot needs the lock, the file is written
+				// after releasing it.
+				var state []byte
+				if *stateFileFlag != "" && time.Since(checkpoint) >= *stateIntervalFlag {
+					data, err := encodeState(targets)
+					if err != nil {
+						fmt.Printf("Failed to write state file: %v\n", err)
 					}
-					if elapsed > maxTime {
-						maxTime = elapsed
+					state = data
+					checkpoint = time.Now()
+				}
+				mu.Unlock()
+				if state != nil {
+					if err := writeStateData(*stateFileFlag, state); err != nil {
+						fmt.Printf("Failed to write state file: %v\n", err)
 					}
-					totalResponseTime += elapsed
-					fmt.Printf("tcping %s:%s in %dms\n", address, port, elapsed)
 				}
 
-				if *countFlag != 0 && i == *countFlag-1 {
+				if !readyAt.IsZero() {
+					fmt.Printf("%s is ready %s after the Wake-on-LAN packet.\n", targets[0], readyAt.Sub(wokeAt).Round(time.Millisecond))
+					stopPing <- true
+					return
+				}
+
+				if rounds != 0 && i == rounds-1 {
 					break
 				}
+				if schedule != nil && (i+1)%*scheduleCountFlag == 0 {
+					continue
+				}
+
+				wait := jitterInterval(timeout, jitter, rnd)
+				if !*strictIntervalFlag {
+					time.Sleep(wait)
+					continue
+				}
 
-				time.Sleep(time.Duration(*timeoutFlag) * time.Second)
+				// Re-anchor the schedule instead of catching up with a burst
+				// when a round overran its slot, e.g. after a pause.
+				next = next.Add(wait)
+				if delay := time.Until(next); delay > 0 {
+					time.Sleep(delay)
+				} else {
+					next = time.Now()
+				}
 			}
 		}
 		stopPing <- true
@@ -95,45 +858,251 @@ func main() {
 	case <-stopPing:
 		fmt.Println("\nPing stopped.")
 	}
+	restoreTerminal()
+
+	// Wait for a round still in flight so the statistics are complete.
+	mu.Lock()
+	end := time.Now()
+	for _, t := range targets {
+		t.outages.close(end)
+	}
+	if dedup != nil {
+		dedup.flushAll(targets)
+	}
+	if agg != nil {
+		agg.flush(targets)
+	}
+	printSummary()
+	if fallback != nil {
+		fallback.printStatistics(targets)
+	}
+	if alerts != nil {
+		alerts.close(targets)
+	}
+	if agent != nil {
+		agent.close()
+	}
+	if zabbix != nil {
+		zabbix.close()
+	}
+	if *stateFileFlag != "" {
+		if err := writeStateFile(*stateFileFlag, targets); err != nil {
+			fmt.Printf("Failed to write state file: %v\n", err)
+		}
+	}
+
+	slaMet := true
+	if *slaFlag > 0 {
+		slaMet = printSLAReport(targets, *slaFlag, end)
+	}
+
+	if *summaryFileFlag != "" {
+		if err := writeSummaryFile(*summaryFileFlag, targets, start); err != nil {
+			fmt.Printf("Failed to write summary file: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if chart != nil {
+		if err := chart.write(targets); err != nil {
+			fmt.Printf("Failed to write chart: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if !slaMet {
+		os.Exit(1)
+	}
+	if wake != nil && readyAt.IsZero() {
+		fmt.Printf("%s did not become ready within %s of the Wake-on-LAN packet.\n", targets[0], end.Sub(wokeAt).Round(time.Second))
+		os.Exit(1)
+	}
+}
+
+// target is a single resolved address and port to probe.
+type target struct {
+	host      string // as given on the command line
+	version   string // "ipv4" or "ipv6"
+	perFamily bool   // host is pinged in both families by --compare-family
+	address   string
+	port      string
+	stats     statistics
+	dns       statistics
+	outages   outageTracker
+	movingAvg *movingAverage
+	hostname  string
+	geo       *geoInfo
+
+	// bucket collects the pings of the current --aggregate period.
+	bucket      statistics
+	bucketStart time.Time
+	buckets     []aggregate
+
+	// banner and httpStatus are what the last ping received, for
+	// --success-if.
+	banner     string
+	httpStatus int
+
+	// lastTime is the RTT of the previous successful ping, if responded.
+	lastTime  time.Duration
+	responded bool
+}
+
+func (t *target) String() string {
+	return t.address + ":" + t.port
+}
+
+// reset clears everything recorded for the target so far.
+func (t *target) reset() {
+	t.stats = statistics{}
+	t.dns = statistics{}
+	t.outages = outageTracker{}
+	t.bucket = statistics{}
+	t.bucketStart = time.Time{}
+	t.buckets = nil
+	if t.movingAvg != nil {
+		t.movingAvg = newMovingAverage(len(t.movingAvg.samples))
+	}
+	t.responded = false
+}
+
+// resolve looks the target's host up again and returns how long the lookup
+// took.
+func (t *target) resolve() (time.Duration, error) {
+	start := time.Now()
+	resolved, err := resolveAddress(t.host, t.version)
+	elapsed := time.Since(start)
+	if err != nil {
+		return elapsed, err
+	}
+	t.address = resolved
+	return elapsed, nil
+}
 
-	printTcpingStatistics(sentCount, respondedCount, minTime, maxTime, totalResponseTime)
+// resolveTargets turns the address/port pairs given on the command line into
+// targets.
+func resolveTargets(args []string, ipv4, ipv6 bool) ([]*target, error) {
+	var targets []*target
+	for i := 0; i < len(args); i += 2 {
+		address := args[i]
+
+		// Default to IPv4 if no -4 or -6 flags specified and address is not explicitly IPv6
+		version := "ipv4"
+		if ipv6 || (!ipv4 && isIPv6(address)) {
+			version = "ipv6"
+		}
+		resolved, err := resolveAddress(address, version)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, &target{host: address, version: version, address: resolved, port: args[i+1]})
+	}
+	return targets, nil
 }
 
-func resolveAddress(address, version string) string {
+// tcping opens a single TCP connection to address:port and returns how long
+// the connect took, measured on the monotonic clock.
+func tcping(address, port string, timeout time.Duration) (time.Duration, error) {
+	rateLimiter.wait()
+
+	start := time.Now()
+	conn, err := net.DialTimeout("tcp", address+":"+port, timeout)
+	elapsed := time.Since(start)
+	if err != nil {
+		return elapsed, err
+	}
+	conn.Close()
+	return elapsed, nil
+}
+
+// resolveFamilies resolves both the IPv4 and the IPv6 address of address so
+// that they can be probed side by side.
+func resolveFamilies(address, port string) ([]*target, error) {
+	var targets []*target
+	for _, version := range []string{"ipv4", "ipv6"} {
+		resolved, err := resolveAddress(address, version)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, &target{host: address, version: version, perFamily: true, address: resolved, port: port})
+	}
+	return targets, nil
+}
+
+// probeFunc sends a single probe to t and returns its RTT along with details
+// to show on the output line, if any.
+type probeFunc func(t *target, timeout time.Duration) (time.Duration, string, error)
+
+// tcpProbe is the default probeFunc, a plain TCP connect.
+func tcpProbe(t *target, timeout time.Duration) (time.Duration, string, error) {
+	elapsed, err := tcping(t.address, t.port, timeout)
+	return elapsed, "", err
+}
+
+// probeWithRetries calls probe until it succeeds or retries more attempts
+// have failed, backing off exponentially between attempts. It returns the
+// result of the last attempt along with its 1-based number.
+func probeWithRetries(probe probeFunc, t *target, timeout time.Duration, retries int, backoff time.Duration) (time.Duration, string, int, error) {
+	attempt := 1
+	for {
+		elapsed, detail, err := probe(t, timeout)
+		if err == nil || attempt > retries {
+			return elapsed, detail, attempt, err
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+		attempt++
+	}
+}
+
+// parseJitter parses an --interval-jitter value such as "20%" into a fraction
+// of the interval.
+func parseJitter(s string) (float64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	percent, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(s), "%"), 64)
+	if err != nil || percent < 0 || percent > 100 {
+		return 0, fmt.Errorf("--interval-jitter must be a percentage between 0%% and 100%%, got %q", s)
+	}
+	return percent / 100, nil
+}
+
+// jitterInterval returns interval moved by a random amount of up to
+// fraction*interval in either direction.
+func jitterInterval(interval time.Duration, fraction float64, rnd *rand.Rand) time.Duration {
+	if fraction == 0 {
+		return interval
+	}
+	return time.Duration(float64(interval) * (1 + fraction*(2*rnd.Float64()-1)))
+}
+
+func resolveAddress(address, version string) (string, error) {
 	ipList, err := net.LookupIP(address)
 	if err != nil {
-		fmt.Printf("Failed to resolve %s: %v\n", address, err)
-		os.Exit(1)
+		return "", fmt.Errorf("Failed to resolve %s: %v", address, err)
 	}
 
 	for _, ip := range ipList {
 		if version == "ipv4" && ip.To4() != nil {
-			return ip.String()
+			return ip.String(), nil
 		} else if version == "ipv6" && ip.To16() != nil && ip.To4() == nil {
-			return "[" + ip.String() + "]"
+			return "[" + ip.String() + "]", nil
 		}
 	}
 
-	fmt.Printf("No %s addresses found for %s\n", version, address)
-	os.Exit(1)
-	return ""
+	return "", fmt.Errorf("No %s addresses found for %s", version, address)
 }
 
-func isIPv4(address string) bool {
-	return strings.Count(address, ":") == 0
+// reverseLookup returns the first PTR name of address, or an empty string if
+// it has none.
+func reverseLookup(address string) string {
+	names, err := net.LookupAddr(strings.Trim(address, "[]"))
+	if err != nil || len(names) == 0 {
+		return ""
+	}
+	return strings.TrimSuffix(names[0], ".")
 }
 
 func isIPv6(address string) bool {
 	return strings.Count(address, ":") >= 2
 }
-
-func printTcpingStatistics(sentCount, respondedCount int, minTime, maxTime, totalResponseTime int64) {
-	fmt.Println("")
-	fmt.Println("--- Tcping Statistics ---")
-	fmt.Printf("%d tcp ping sent, %d tcp ping responsed, %.2f%% loss\n", sentCount, respondedCount, float64(sentCount-respondedCount)/float64(sentCount)*100)
-	if respondedCount > 0 {
-		fmt.Printf("min/avg/max = %dms/%dms/%dms\n", minTime, totalResponseTime/int64(respondedCount), maxTime)
-	} else {
-		fmt.Println("No responses received.")
-	}
-}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Nagios plugin exit codes.
+const (
+	nagiosOK       = 0
+	nagiosWarning  = 1
+	nagiosCritical = 2
+	nagiosUnknown  = 3
+)
+
+// nagiosDefaultCount is the number of probes sent in Nagios mode when -n is
+// not given, since a plugin must always terminate.
+const nagiosDefaultCount = 5
+
+var nagiosStatusNames = []string{"OK", "WARNING", "CRITICAL", "UNKNOWN"}
+
+type nagiosThreshold struct {
+	rta  time.Duration // average round trip time
+	loss float64       // packet loss in percent
+}
+
+// parseNagiosThreshold parses a check_ping style "rta,pl%" threshold such as
+// "100,20%".
+func parseNagiosThreshold(s string) (nagiosThreshold, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 2 {
+		return nagiosThreshold{}, fmt.Errorf("invalid threshold %q, expected rta,pl%%", s)
+	}
+
+	rta, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(parts[0]), "ms"), 64)
+	if err != nil {
+		return nagiosThreshold{}, fmt.Errorf("invalid rta in threshold %q: %v", s, err)
+	}
+	loss, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(parts[1]), "%"), 64)
+	if err != nil {
+		return nagiosThreshold{}, fmt.Errorf("invalid packet loss in threshold %q: %v", s, err)
+	}
+
+	return nagiosThreshold{rta: time.Duration(rta * float64(time.Millisecond)), loss: loss}, nil
+}
+
+func (t nagiosThreshold) exceeded(rta time.Duration, loss float64) bool {
+	return rta >= t.rta || loss >= t.loss
+}
+
+// runNagios sends a fixed number of probes, prints a single plugin status
+// line with perfdata and returns the plugin exit code.
+func runNagios(t *target, probe probeFunc, count int, timeout time.Duration, warning, critical string) int {
+	warn, err := parseNagiosThreshold(warning)
+	if err != nil {
+		fmt.Printf("TCPING UNKNOWN - %v\n", err)
+		return nagiosUnknown
+	}
+	crit, err := parseNagiosThreshold(critical)
+	if err != nil {
+		fmt.Printf("TCPING UNKNOWN - %v\n", err)
+		return nagiosUnknown
+	}
+
+	if count <= 0 {
+		count = nagiosDefaultCount
+	}
+
+	s := &t.stats
+	for i := 0; i < count; i++ {
+		elapsed, _, err := probe(t, timeout)
+		s.add(elapsed, err)
+
+		if i < count-1 {
+			time.Sleep(timeout)
+		}
+	}
+
+	loss := s.loss()
+	status := nagiosOK
+	rta := "U"
+	if s.respondedCount == 0 {
+		status = nagiosCritical
+	} else {
+		avg := s.avg()
+		rta = fmt.Sprintf("%.3fms", milliseconds(avg))
+		if crit.exceeded(avg, loss) {
+			status = nagiosCritical
+		} else if warn.exceeded(avg, loss) {
+			status = nagiosWarning
+		}
+	}
+
+	fmt.Printf("TCPING %s - %s rta %s, lost %.0f%% | rta=%s;%.3f;%.3f;0 pl=%.0f%%;%.0f;%.0f;0\n",
+		nagiosStatusNames[status], t, rta, loss,
+		rta, milliseconds(warn.rta), milliseconds(crit.rta), loss, warn.loss, crit.loss)
+	return status
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseNagiosThreshold(t *testing.T) {
+	tests := []struct {
+		in   string
+		want nagiosThreshold
+	}{
+		{"100,20%", nagiosThreshold{100 * time.Millisecond, 20}},
+		{"500,60%", nagiosThreshold{500 * time.Millisecond, 60}},
+		{"0.5ms, 5%", nagiosThreshold{500 * time.Microsecond, 5}},
+		{"250,0", nagiosThreshold{250 * time.Millisecond, 0}},
+		{" 1.25 ,12.5% ", nagiosThreshold{1250 * time.Microsecond, 12.5}},
+	}
+	for _, tt := range tests {
+		got, err := parseNagiosThreshold(tt.in)
+		if err != nil {
+			t.Errorf("parseNagiosThreshold(%q) failed: %v", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseNagiosThreshold(%q) = %+v, want %+v", tt.in, got, tt.want)
+		}
+	}
+
+	for _, in := range []string{"", "100", "100,20%,5", "fast,20%", "100,lots", "100s,20%", ",20%"} {
+		if got, err := parseNagiosThreshold(in); err == nil {
+			t.Errorf("parseNagiosThreshold(%q) = %+v, want an error", in, got)
+		}
+	}
+}
+
+func TestRunNagios(t *testing.T) {
+	tests := []struct {
+		name  string
+		rtts  []time.Duration // a zero RTT is a lost ping
+		count int
+		want  int
+	}{
+		{"ok", []time.Duration{10 * time.Millisecond, 20 * time.Millisecond}, 2, nagiosOK},
+		{"slow", []time.Duration{150 * time.Millisecond}, 1, nagiosWarning},
+		{"very slow", []time.Duration{600 * time.Millisecond}, 1, nagiosCritical},
+		{"some loss", []time.Duration{10 * time.Millisecond, 10 * time.Millisecond, 10 * time.Millisecond, 10 * time.Millisecond, 0}, 5, nagiosWarning},
+		{"much loss", []time.Duration{10 * time.Millisecond, 0}, 2, nagiosWarning},
+		{"most lost", []time.Duration{10 * time.Millisecond, 0, 0}, 3, nagiosCritical},
+		{"all lost", []time.Duration{0}, 1, nagiosCritical},
+		{"default count", []time.Duration{10 * time.Millisecond}, 0, nagiosOK},
+	}
+	for _, tt := range tests {
+		calls := 0
+		probe := func(*target, time.Duration) (time.Duration, string, error) {
+			rtt := tt.rtts[calls%len(tt.rtts)]
+			calls++
+			if rtt == 0 {
+				return 0, "", errors.New("timeout")
+			}
+			return rtt, "", nil
+		}
+		tg := &target{address: "192.0.2.1", port: "80"}
+		if got := runNagios(tg, probe, tt.count, time.Millisecond, "100,20%", "500,60%"); got != tt.want {
+			t.Errorf("%s: runNagios() = %s, want %s", tt.name, nagiosStatusNames[got], nagiosStatusNames[tt.want])
+		}
+		want := tt.count
+		if want == 0 {
+			want = nagiosDefaultCount
+		}
+		if calls != want {
+			t.Errorf("%s: runNagios() sent %d probes, want %d", tt.name, calls, want)
+		}
+	}
+
+	probe := func(*target, time.Duration) (time.Duration, string, error) { return time.Millisecond, "", nil }
+	if got := runNagios(&target{}, probe, 1, time.Millisecond, "100", "500,60%"); got != nagiosUnknown {
+		t.Errorf("runNagios() with an invalid threshold = %s, want UNKNOWN", nagiosStatusNames[got])
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// The OCSP structures from RFC 6960, only as far as needed to read the
+// certificate status. Response signatures are not verified, the result is
+// for diagnostics only.
+
+type ocspRequest struct {
+	TBSRequest struct {
+		RequestList []struct {
+			Cert ocspCertID
+		}
+	}
+}
+
+type ocspCertID struct {
+	HashAlgorithm  pkix.AlgorithmIdentifier
+	IssuerNameHash []byte
+	IssuerKeyHash  []byte
+	SerialNumber   *big.Int
+}
+
+type ocspResponse struct {
+	Status   asn1.Enumerated
+	Response struct {
+		ResponseType asn1.ObjectIdentifier
+		Response     []byte
+	} `asn1:"explicit,tag:0,optional"`
+}
+
+type ocspBasicResponse struct {
+	TBSResponseData struct {
+		Raw            asn1.RawContent
+		Version        int `asn1:"optional,default:0,explicit,tag:0"`
+		RawResponderID asn1.RawValue
+		ProducedAt     time.Time `asn1:"generalized"`
+		Responses      []ocspSingleResponse
+	}
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          asn1.BitString
+}
+
+type ocspSingleResponse struct {
+	CertID  ocspCertID
+	Good    asn1.Flag `asn1:"tag:0,optional"`
+	Revoked struct {
+		RevocationTime time.Time `asn1:"generalized"`
+	} `asn1:"tag:1,optional"`
+	Unknown    asn1.Flag `asn1:"tag:2,optional"`
+	ThisUpdate time.Time `asn1:"generalized"`
+	NextUpdate time.Time `asn1:"generalized,explicit,tag:0,optional"`
+}
+
+var (
+	oidSHA1              = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	oidOCSPBasicResponse = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 1}
+)
+
+var ocspStatusNames = map[asn1.Enumerated]string{
+	1: "malformed request",
+	2: "internal error",
+	3: "try later",
+	5: "signature required",
+	6: "unauthorized",
+}
+
+// checkOCSP reports the revocation status of the server certificate from the
+// stapled OCSP response, or from the certificate's OCSP responder when
+// nothing was stapled. A revoked certificate or an expired staple is
+// returned as an error so the probe counts as failed.
+func checkOCSP(state tls.ConnectionState, timeout time.Duration) (string, error) {
+	if len(state.PeerCertificates) == 0 {
+		return "", fmt.Errorf("no certificate presented")
+	}
+	leaf := state.PeerCertificates[0]
+
+	if len(state.OCSPResponse) > 0 {
+		status, err := ocspStatus(state.OCSPResponse, leaf)
+		if err != nil {
+			return "", fmt.Errorf("invalid OCSP staple: %v", err)
+		}
+		if !status.NextUpdate.IsZero() && time.Now().After(status.NextUpdate) {
+			return "", fmt.Errorf("OCSP staple expired %s ago", time.Since(status.NextUpdate).Round(time.Second))
+		}
+		return describeOCSP(status, "stapled")
+	}
+
+	issuer := ocspIssuer(state)
+	if issuer == nil || len(leaf.OCSPServer) == 0 {
+		return "no OCSP staple", nil
+	}
+	status, failure := responderStatus(leaf, issuer, timeout)
+	if status == nil {
+		return "no OCSP staple, " + failure, nil
+	}
+	return describeOCSP(status, "no staple, from responder")
+}
+
+// ocspRetry is how long a failed or already stale responder answer is kept
+// before the responder is asked again.
+const ocspRetry = 10 * time.Minute
+
+// ocspAnswers keeps the responder answers by certificate serial, so that the
+// CA's responder is asked once per certificate until its answer expires
+// rather than on every ping.
+var ocspAnswers = struct {
+	sync.Mutex
+	entries map[string]ocspAnswer
+}{entries: make(map[string]ocspAnswer)}
+
+type ocspAnswer struct {
+	status  *ocspSingleResponse // nil if the query failed
+	failure string
+	expires time.Time // zero if the answer has no next update
+}
+
+// responderStatus returns the status of leaf from its OCSP responder, or why
+// there is none.
+func responderStatus(leaf, issuer *x509.Certificate, timeout time.Duration) (*ocspSingleResponse, string) {
+	ocspAnswers.Lock()
+	defer ocspAnswers.Unlock()
+	key := leaf.SerialNumber.String()
+	if a, ok := ocspAnswers.entries[key]; ok && (a.expires.IsZero() || time.Now().Before(a.expires)) {
+		return a.status, a.failure
+	}
+
+	a := ocspAnswer{expires: time.Now().Add(ocspRetry)}
+	if der, err := queryOCSP(leaf.OCSPServer[0], leaf, issuer, timeout); err != nil {
+		a.failure = "responder query failed: " + err.Error()
+	} else if a.status, err = ocspStatus(der, leaf); err != nil {
+		a.failure = "invalid responder answer: " + err.Error()
+	} else if next := a.status.NextUpdate; next.IsZero() || next.After(a.expires) {
+		a.expires = next
+	}
+	ocspAnswers.entries[key] = a
+	return a.status, a.failure
+}
+
+func describeOCSP(status *ocspSingleResponse, source string) (string, error) {
+	switch {
+	case bool(status.Good):
+		s := fmt.Sprintf("OCSP good (%s, updated %s ago", source, time.Since(status.ThisUpdate).Round(time.Second))
+		if !status.NextUpdate.IsZero() {
+			s += fmt.Sprintf(", valid for %s", time.Until(status.NextUpdate).Round(time.Second))
+		}
+		return s + ")", nil
+	case bool(status.Unknown):
+		return fmt.Sprintf("OCSP unknown (%s)", source), nil
+	default:
+		return "", fmt.Errorf("certificate revoked at %s (OCSP, %s)", status.Revoked.RevocationTime.Format(time.RFC3339), source)
+	}
+}
+
+// ocspIssuer returns the certificate that issued the server certificate,
+// preferring the verified chain over what the server sent.
+func ocspIssuer(state tls.ConnectionState) *x509.Certificate {
+	if len(state.VerifiedChains) > 0 && len(state.VerifiedChains[0]) > 1 {
+		return state.VerifiedChains[0][1]
+	}
+	if len(state.PeerCertificates) > 1 {
+		return state.PeerCertificates[1]
+	}
+	return nil
+}
+
+// ocspStatus parses a DER encoded OCSP response and returns the entry for
+// cert.
+func ocspStatus(der []byte, cert *x509.Certificate) (*ocspSingleResponse, error) {
+	var resp ocspResponse
+	if _, err := asn1.Unmarshal(der, &resp); err != nil {
+		return nil, err
+	}
+	if resp.Status != 0 {
+		if name, ok := ocspStatusNames[resp.Status]; ok {
+			return nil, fmt.Errorf("responder returned %s", name)
+		}
+		return nil, fmt.Errorf("responder returned status %d", resp.Status)
+	}
+	if !resp.Response.ResponseType.Equal(oidOCSPBasicResponse) {
+		return nil, fmt.Errorf("unsupported response type %v", resp.Response.ResponseType)
+	}
+
+	var basic ocspBasicResponse
+	if _, err := asn1.Unmarshal(resp.Response.Response, &basic); err != nil {
+		return nil, err
+	}
+	for i, r := range basic.TBSResponseData.Responses {
+		if r.CertID.SerialNumber != nil && r.CertID.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+			return &basic.TBSResponseData.Responses[i], nil
+		}
+	}
+	return nil, fmt.Errorf("no status for serial %x", cert.SerialNumber)
+}
+
+// queryOCSP asks the responder at url for the status of cert.
+func queryOCSP(url string, cert, issuer *x509.Certificate, timeout time.Duration) ([]byte, error) {
+	var spki struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(issuer.RawSubjectPublicKeyInfo, &spki); err != nil {
+		return nil, err
+	}
+	nameHash := sha1.Sum(issuer.RawSubject)
+	keyHash := sha1.Sum(spki.PublicKey.RightAlign())
+
+	var req ocspRequest
+	req.TBSRequest.RequestList = append(req.TBSRequest.RequestList, struct{ Cert ocspCertID }{ocspCertID{
+		HashAlgorithm:  pkix.AlgorithmIdentifier{Algorithm: oidSHA1, Parameters: asn1.NullRawValue},
+		IssuerNameHash: nameHash[:],
+		IssuerKeyHash:  keyHash[:],
+		SerialNumber:   cert.SerialNumber,
+	}})
+	body, err := asn1.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	client := &http.Client{Timeout: timeout}
+	resp, err := client.Post(url, "application/ocsp-request", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP %s", resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// encodeOCSP builds a DER encoded OCSP response with the given status and
+// single responses, the way a responder or a staple would carry it.
+func encodeOCSP(t *testing.T, status asn1.Enumerated, responseType asn1.ObjectIdentifier, responses ...ocspSingleResponse) []byte {
+	t.Helper()
+	var basic ocspBasicResponse
+	basic.TBSResponseData.RawResponderID = asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 2, IsCompound: true, Bytes: []byte{asn1.TagOctetString, 1, 0}}
+	basic.TBSResponseData.ProducedAt = time.Date(2026, 10, 14, 10, 0, 0, 0, time.UTC)
+	basic.TBSResponseData.Responses = responses
+	basic.SignatureAlgorithm.Algorithm = oidSHA1
+	basic.Signature = asn1.BitString{Bytes: []byte{0}, BitLength: 8}
+	inner, err := asn1.Marshal(basic)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp := ocspResponse{Status: status}
+	if status == 0 {
+		resp.Response.ResponseType = responseType
+		resp.Response.Response = inner
+	}
+	der, err := asn1.Marshal(resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+func TestOCSPStatus(t *testing.T) {
+	thisUpdate := time.Date(2026, 10, 14, 9, 0, 0, 0, time.UTC)
+	single := func(serial int64) ocspSingleResponse {
+		r := ocspSingleResponse{ThisUpdate: thisUpdate}
+		r.CertID.HashAlgorithm.Algorithm = oidSHA1
+		r.CertID.IssuerNameHash = make([]byte, 20)
+		r.CertID.IssuerKeyHash = make([]byte, 20)
+		r.CertID.SerialNumber = big.NewInt(serial)
+		return r
+	}
+	good := single(42)
+	good.Good = true
+	good.NextUpdate = thisUpdate.Add(24 * time.Hour)
+	revoked := single(42)
+	revoked.Revoked.RevocationTime = time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	unknown := single(42)
+	unknown.Unknown = true
+	other := single(7)
+	other.Good = true
+
+	cert := &x509.Certificate{SerialNumber: big.NewInt(42)}
+	tests := []struct {
+		name   string
+		der    []byte
+		check  func(r *ocspSingleResponse) bool
+		errMsg string
+	}{
+		{"good", encodeOCSP(t, 0, oidOCSPBasicResponse, good),
+			func(r *ocspSingleResponse) bool { return bool(r.Good) && r.NextUpdate.Equal(good.NextUpdate) }, ""},
+		{"revoked", encodeOCSP(t, 0, oidOCSPBasicResponse, revoked),
+			func(r *ocspSingleResponse) bool {
+				return !bool(r.Good) && r.Revoked.RevocationTime.Equal(revoked.Revoked.RevocationTime)
+			}, ""},
+		{"unknown", encodeOCSP(t, 0, oidOCSPBasicResponse, unknown),
+			func(r *ocspSingleResponse) bool { return bool(r.Unknown) && r.NextUpdate.IsZero() }, ""},
+		{"serial lookup", encodeOCSP(t, 0, oidOCSPBasicResponse, other, good),
+			func(r *ocspSingleResponse) bool { return r.CertID.SerialNumber.Int64() == 42 && bool(r.Good) }, ""},
+		{"other serial", encodeOCSP(t, 0, oidOCSPBasicResponse, other), nil, "no status for serial 2a"},
+		{"try later", encodeOCSP(t, 3, nil), nil, "responder returned try later"},
+		{"unknown status", encodeOCSP(t, 4, nil), nil, "responder returned status 4"},
+		{"response type", encodeOCSP(t, 0, asn1.ObjectIdentifier{1, 2, 3}, good), nil, "unsupported response type"},
+		{"garbage", []byte{0x30, 0x03, 0x0a}, nil, "asn1"},
+	}
+	for _, tt := range tests {
+		r, err := ocspStatus(tt.der, cert)
+		if tt.errMsg != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+				t.Errorf("%s: ocspStatus() error = %v, want %q", tt.name, err, tt.errMsg)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: ocspStatus() failed: %v", tt.name, err)
+			continue
+		}
+		if !tt.check(r) {
+			t.Errorf("%s: ocspStatus() = %+v", tt.name, *r)
+		}
+	}
+}
+
+func TestDescribeOCSP(t *testing.T) {
+	var good, revoked, unknown ocspSingleResponse
+	good.Good = true
+	good.ThisUpdate = time.Now().Add(-time.Hour)
+	revoked.Revoked.RevocationTime = time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	unknown.Unknown = true
+
+	if s, err := describeOCSP(&good, "stapled"); err != nil || !strings.HasPrefix(s, "OCSP good (stapled, updated 1h0m0s ago") {
+		t.Errorf("describeOCSP(good) = %q, %v", s, err)
+	}
+	if s, err := describeOCSP(&unknown, "stapled"); err != nil || s != "OCSP unknown (stapled)" {
+		t.Errorf("describeOCSP(unknown) = %q, %v", s, err)
+	}
+	if _, err := describeOCSP(&revoked, "stapled"); err == nil || err.Error() != "certificate revoked at 2026-10-01T00:00:00Z (OCSP, stapled)" {
+		t.Errorf("describeOCSP(revoked) error = %v", err)
+	}
+}
+
+func TestResponderStatusCached(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "tcping test CA"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	answer := ocspSingleResponse{Good: true, ThisUpdate: time.Now().Add(-time.Hour).UTC().Truncate(time.Second)}
+	answer.CertID.HashAlgorithm.Algorithm = oidSHA1
+	answer.CertID.IssuerNameHash = make([]byte, 20)
+	answer.CertID.IssuerKeyHash = make([]byte, 20)
+	answer.CertID.SerialNumber = big.NewInt(1001)
+	answer.NextUpdate = time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	good := encodeOCSP(t, 0, oidOCSPBasicResponse, answer)
+
+	requests := 0
+	responder := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.URL.Path == "/down" {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write(good)
+	}))
+	defer responder.Close()
+
+	tests := []struct {
+		name    string
+		serial  int64
+		path    string
+		failure string
+	}{
+		{"good", 1001, "/", ""},
+		{"unreachable", 1002, "/down", "responder query failed: HTTP 503 Service Unavailable"},
+	}
+	for _, tt := range tests {
+		requests = 0
+		leaf := &x509.Certificate{SerialNumber: big.NewInt(tt.serial), OCSPServer: []string{responder.URL + tt.path}}
+		for i := 0; i < 3; i++ {
+			status, failure := responderStatus(leaf, issuer, time.Second)
+			if failure != tt.failure || (status == nil) != (tt.failure != "") {
+				t.Errorf("%s: responderStatus() = %v, %q, want failure %q", tt.name, status, failure, tt.failure)
+			}
+		}
+		if requests != 1 {
+			t.Errorf("%s: the responder was asked %d times, want once", tt.name, requests)
+		}
+	}
+}
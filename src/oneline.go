@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// onelineStatus replaces the scrolling output of --oneline with a single
+// status line that is rewritten in place after every round.
+type onelineStatus struct {
+	targets []*target
+	last    map[*target]string
+}
+
+func newOnelineStatus(targets []*target) *onelineStatus {
+	return &onelineStatus{targets: targets, last: make(map[*target]string)}
+}
+
+// result remembers the RTT of the latest ping of each target.
+func (o *onelineStatus) result(t *target, line string, elapsed time.Duration, err error) {
+	if err != nil {
+		o.last[t] = "fail"
+	} else {
+		o.last[t] = formatRTT(elapsed)
+	}
+}
+
+// draw rewrites the status line.
+func (o *onelineStatus) draw() {
+	var parts []string
+	for _, t := range o.targets {
+		s := t.stats
+		status := fmt.Sprintf("seq=%d rtt=%s", s.sentCount, o.last[t])
+		// Warmup pings are not counted, so there may be no loss yet.
+		if s.sentCount > 0 {
+			status += fmt.Sprintf(" loss=%.1f%%", s.loss())
+		}
+		if s.respondedCount > 0 {
+			status += " avg=" + formatRTT(s.avg())
+		}
+		if len(o.targets) > 1 {
+			status = t.String() + " " + status
+		}
+		parts = append(parts, status)
+	}
+	fmt.Print("\r\033[K" + strings.Join(parts, " | "))
+}
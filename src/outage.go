@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// downAfter and upAfter are how many consecutive failed or successful pings
+// it takes for a target to be considered down or up again, --down-after and
+// --up-after.
+var downAfter, upAfter = 1, 1
+
+// outage is a run of consecutive failed pings, from the first failed ping
+// until the next successful one. With --down-after and --up-after it starts
+// at the first of downAfter failed pings in a row and ends at the first of
+// upAfter successful ones.
+type outage struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	Lost  int       `json:"lost"`
+}
+
+func (o outage) duration() time.Duration {
+	return o.End.Sub(o.Start)
+}
+
+// outageTracker turns the stream of ping results of one target into a list
+// of outages.
+type outageTracker struct {
+	first   time.Time
+	last    time.Time
+	current *outage
+	outages []outage
+
+	// run counts the consecutive pings disagreeing with the current state,
+	// which started at runStart.
+	run      int
+	runStart time.Time
+
+	// unobserved is time without pings within first..last, the gaps
+	// between the runs resumed from --state-file. resumed is set until the
+	// first ping after resuming.
+	unobserved time.Duration
+	resumed    bool
+}
+
+// record accounts a ping sent at the given time.
+func (o *outageTracker) record(at time.Time, ok bool) {
+	if o.first.IsZero() {
+		o.first = at
+	}
+	if o.resumed {
+		o.unobserved += at.Sub(o.last)
+		o.resumed = false
+	}
+	o.last = at
+
+	if ok == (o.current == nil) {
+		// The ping agrees with the state, a run towards a change is
+		// broken.
+		if o.current != nil {
+			o.current.Lost++
+		}
+		o.run = 0
+		return
+	}
+
+	if o.run == 0 {
+		o.runStart = at
+	}
+	o.run++
+	switch {
+	case ok && o.run >= upAfter:
+		o.current.End = o.runStart
+		o.outages = append(o.outages, *o.current)
+		o.current = nil
+		o.run = 0
+	case !ok && o.run >= downAfter:
+		o.current = &outage{Start: o.runStart, Lost: o.run}
+		o.run = 0
+	}
+}
+
+// down reports whether the target is in an outage, and since when.
+func (o *outageTracker) down() (bool, time.Time) {
+	if o.current == nil {
+		return false, time.Time{}
+	}
+	return true, o.current.Start
+}
+
+// close ends an outage that is still ongoing when the run stops.
+func (o *outageTracker) close(at time.Time) {
+	if o.current != nil {
+		o.current.End = at
+		o.outages = append(o.outages, *o.current)
+		o.current = nil
+	}
+	o.run = 0
+	if !o.first.IsZero() {
+		o.last = at
+	}
+}
+
+// downtime returns the total duration of all closed outages.
+func (o *outageTracker) downtime() time.Duration {
+	var total time.Duration
+	for _, out := range o.outages {
+		total += out.duration()
+	}
+	return total
+}
+
+// longest returns the duration of the longest closed outage.
+func (o *outageTracker) longest() time.Duration {
+	var longest time.Duration
+	for _, out := range o.outages {
+		if d := out.duration(); d > longest {
+			longest = d
+		}
+	}
+	return longest
+}
+
+// availability returns the percentage of the observed time the target was
+// not in an outage.
+func (o *outageTracker) availability() float64 {
+	observed := o.last.Sub(o.first) - o.unobserved
+	if observed <= 0 {
+		if len(o.outages) > 0 {
+			return 0
+		}
+		return 100
+	}
+	return (1 - float64(o.downtime())/float64(observed)) * 100
+}
+
+func printOutages(o *outageTracker) {
+	if len(o.outages) == 0 {
+		return
+	}
+	fmt.Printf("%d outages, %s downtime, longest %s\n", len(o.outages),
+		o.downtime().Round(time.Millisecond), o.longest().Round(time.Millisecond))
+}
+
+// printSLAReport prints the achieved availability and the consumed error
+// budget of every target, and reports whether all of them met sla.
+func printSLAReport(targets []*target, sla float64, end time.Time) bool {
+	fmt.Println("")
+	fmt.Printf("--- SLA Report (%.3f%%) ---\n", sla)
+
+	met := true
+	for _, t := range targets {
+		o := &t.outages
+		observed := o.last.Sub(o.first) - o.unobserved
+		budget := time.Duration(float64(observed) * (100 - sla) / 100)
+		availability := o.availability()
+
+		consumed := "n/a"
+		if budget > 0 {
+			consumed = fmt.Sprintf("%.1f%%", float64(o.downtime())/float64(budget)*100)
+		}
+
+		status := "met"
+		if availability < sla {
+			status = "MISSED"
+			met = false
+		}
+		fmt.Printf("%s: %.3f%% available over %s, %s downtime, error budget %s, %s consumed, SLA %s\n",
+			t, availability, observed.Round(time.Second), o.downtime().Round(time.Millisecond),
+			budget.Round(time.Millisecond), consumed, status)
+	}
+	return met
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestOutageTracker(t *testing.T) {
+	defer func(d, u int) { downAfter, upAfter = d, u }(downAfter, upAfter)
+
+	// Every character of pings is one ping a second, '.' a success and 'x' a
+	// failure. Outages are given as the pings they start and end at.
+	type span struct{ start, end, lost int }
+	tests := []struct {
+		name             string
+		down, up         int
+		pings            string
+		want             []span
+		downSince        int // ping the ongoing outage started at, -1 if up
+		wantAvailability float64
+	}{
+		{"single failure", 1, 1, "..x..", []span{{2, 3, 1}}, -1, 75},
+		{"no failures", 1, 1, ".....", nil, -1, 100},
+		{"opened after N failures", 3, 1, "..xxx..", []span{{2, 5, 3}}, -1, 50},
+		{"interrupted run", 3, 1, "..xx.xx..", nil, -1, 100},
+		{"closed after M successes", 1, 2, ".xx.x..", []span{{1, 5, 3}}, -1, 100 * 2 / 6.0},
+		{"success run interrupted", 1, 3, "x..x...", []span{{0, 4, 2}}, -1, 100 * 2 / 6.0},
+		{"ongoing", 2, 2, "xx..xx.", []span{{0, 2, 2}}, 4, 100 * 4 / 6.0},
+		{"still down", 1, 1, "..xxx", nil, 2, 100},
+	}
+	start := time.Date(2026, 10, 14, 10, 0, 0, 0, time.UTC)
+	at := func(i int) time.Time { return start.Add(time.Duration(i) * time.Second) }
+
+	for _, tt := range tests {
+		downAfter, upAfter = tt.down, tt.up
+		var o outageTracker
+		for i, c := range tt.pings {
+			o.record(at(i), c == '.')
+		}
+
+		var got []span
+		for _, out := range o.outages {
+			got = append(got, span{int(out.Start.Sub(start) / time.Second), int(out.End.Sub(start) / time.Second), out.Lost})
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: outages %v, want %v", tt.name, got, tt.want)
+		}
+
+		down, since := o.down()
+		if want := tt.downSince >= 0; down != want || (down && !since.Equal(at(tt.downSince))) {
+			t.Errorf("%s: down() = %v, %s, want down since ping %d", tt.name, down, since, tt.downSince)
+		}
+		if got := o.availability(); got < tt.wantAvailability-1e-9 || got > tt.wantAvailability+1e-9 {
+			t.Errorf("%s: availability() = %.3f, want %.3f", tt.name, got, tt.wantAvailability)
+		}
+	}
+}
+
+func TestOutageTrackerClose(t *testing.T) {
+	defer func(d, u int) { downAfter, upAfter = d, u }(downAfter, upAfter)
+	downAfter, upAfter = 1, 1
+
+	start := time.Date(2026, 10, 14, 10, 0, 0, 0, time.UTC)
+	var o outageTracker
+	o.record(start, true)
+	o.record(start.Add(time.Second), false)
+	o.record(start.Add(2*time.Second), false)
+	end := start.Add(4 * time.Second)
+	o.close(end)
+
+	// The outage still going on at the end lasts until then.
+	want := []outage{{Start: start.Add(time.Second), End: end, Lost: 2}}
+	if !reflect.DeepEqual(o.outages, want) {
+		t.Errorf("outages after close %+v, want %+v", o.outages, want)
+	}
+	if down, _ := o.down(); down {
+		t.Error("still down after close")
+	}
+	if o.downtime() != 3*time.Second || o.longest() != 3*time.Second {
+		t.Errorf("downtime %s, longest %s, want 3s each", o.downtime(), o.longest())
+	}
+	if got := o.availability(); got != 25 {
+		t.Errorf("availability() = %.3f, want 25", got)
+	}
+}
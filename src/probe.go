@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Prober sends a single probe to a target. The context carries the timeout
+// of the probe as its deadline.
+type Prober interface {
+	Probe(ctx context.Context, t *target) Result
+}
+
+// Result is the outcome of a single probe.
+type Result struct {
+	RTT    time.Duration
+	Detail string // shown in parentheses on the output line
+	Err    error
+}
+
+// proberConfig holds the command line options probers are created from.
+type proberConfig struct {
+	tls     bool // --tls was given, for HTTPS
+	tlsOpts tlsOptions
+	http    httpOptions
+}
+
+// proberFactory creates a prober from the command line options.
+type proberFactory func(cfg *proberConfig) (Prober, error)
+
+var probers = map[string]proberFactory{}
+
+// registerProber makes a prober available as --probe name. Custom probes
+// are compiled in by adding a file to this package that registers them from
+// an init function:
+//
+//	func init() {
+//		registerProber("redis", func(cfg *proberConfig) (Prober, error) {
+//			return redisProber{}, nil
+//		})
+//	}
+func registerProber(name string, factory proberFactory) {
+	if _, ok := probers[name]; ok {
+		panic("prober " + name + " registered twice")
+	}
+	probers[name] = factory
+}
+
+func init() {
+	registerProber("tcp", func(cfg *proberConfig) (Prober, error) {
+		return probeFunc(tcpProbe), nil
+	})
+	registerProber("banner", func(cfg *proberConfig) (Prober, error) {
+		return probeFunc(bannerProbe), nil
+	})
+	registerProber("syn", func(cfg *proberConfig) (Prober, error) {
+		probe, err := newSYNProbe()
+		if err != nil {
+			return nil, err
+		}
+		return probe, nil
+	})
+	registerProber("tls", func(cfg *proberConfig) (Prober, error) {
+		p, err := newTLSProber(cfg.tlsOpts)
+		if err != nil {
+			return nil, err
+		}
+		return probeFunc(p.probe), nil
+	})
+	registerProber("http", func(cfg *proberConfig) (Prober, error) {
+		var tlsProbe *tlsProber
+		if cfg.tls {
+			var err error
+			if tlsProbe, err = newTLSProber(cfg.tlsOpts); err != nil {
+				return nil, err
+			}
+		}
+		p, err := newHTTPProber(tlsProbe, cfg.http)
+		if err != nil {
+			return nil, err
+		}
+		return probeFunc(p.probe), nil
+	})
+}
+
+// newProber creates the prober registered as name.
+func newProber(name string, cfg *proberConfig) (Prober, error) {
+	factory, ok := probers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown probe %q, expected one of %s", name, strings.Join(proberNames(), ", "))
+	}
+	return factory(cfg)
+}
+
+// proberNames returns the names of all registered probers in order.
+func proberNames() []string {
+	names := make([]string, 0, len(probers))
+	for name := range probers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Probe makes a probeFunc usable as a Prober.
+func (probe probeFunc) Probe(ctx context.Context, t *target) Result {
+	var timeout time.Duration
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = time.Until(deadline)
+	}
+	rtt, detail, err := probe(t, timeout)
+	return Result{RTT: rtt, Detail: detail, Err: err}
+}
+
+// proberFunc turns p into the probeFunc the ping loop and the modes wrapping
+// probes work with. A timeout of 0 leaves the probe without a deadline.
+func proberFunc(p Prober) probeFunc {
+	if probe, ok := p.(probeFunc); ok {
+		return probe
+	}
+	return func(t *target, timeout time.Duration) (time.Duration, string, error) {
+		ctx := context.Background()
+		if timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+		r := p.Probe(ctx, t)
+		return r.RTT, r.Detail, r.Err
+	}
+}
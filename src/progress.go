@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const progressWidth = 30
+
+// progressBar renders a progress bar with an ETA below the output of a
+// fixed-count run. The bar is drawn without a trailing newline and cleared
+// before the next result is printed.
+type progressBar struct {
+	total    int
+	done     int
+	interval time.Duration
+	start    time.Time
+}
+
+func newProgressBar(total int, interval time.Duration) *progressBar {
+	return &progressBar{total: total, interval: interval, start: time.Now()}
+}
+
+// clear removes the bar so that regular output can be printed.
+func (p *progressBar) clear() {
+	if p.done > 0 {
+		fmt.Print("\r\033[K")
+	}
+}
+
+// draw accounts a finished round and redraws the bar. The ETA assumes the
+// remaining rounds take as long as the average round so far, including the
+// interval that follows each of them.
+func (p *progressBar) draw() {
+	p.done++
+	filled := p.done * progressWidth / p.total
+	bar := strings.Repeat("#", filled) + strings.Repeat(" ", progressWidth-filled)
+
+	// The interval after the round just finished has not been waited yet.
+	perRound := (time.Since(p.start) + p.interval) / time.Duration(p.done)
+	eta := perRound * time.Duration(p.total-p.done)
+	fmt.Printf("\r\033[K[%s] %3d%% %d/%d ETA %s", bar, p.done*100/p.total, p.done, p.total, eta.Round(time.Second))
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// rateLimiter caps the rate of all probes sent by tcping when --max-rate is
+// set. It is nil otherwise.
+var rateLimiter *tokenBucket
+
+// tokenBucket is a token bucket holding at most one token, so probes are
+// evenly paced instead of being allowed to go out in bursts.
+type tokenBucket struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+func newTokenBucket(rate float64) *tokenBucket {
+	return &tokenBucket{interval: time.Duration(float64(time.Second) / rate)}
+}
+
+// wait blocks until a token is available and takes it. It is safe to call on
+// a nil bucket, in which case it returns immediately.
+func (b *tokenBucket) wait() {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	now := time.Now()
+	if b.next.Before(now) {
+		b.next = now
+	}
+	delay := b.next.Sub(now)
+	b.next = b.next.Add(b.interval)
+	b.mu.Unlock()
+
+	time.Sleep(delay)
+}
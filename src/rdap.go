@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// rdapAPI bootstraps the lookup through rdap.org, which redirects to the
+// regional registry responsible for the address.
+const rdapAPI = "https://rdap.org/ip/%s"
+
+const rdapTimeout = 10 * time.Second
+
+type rdapNetwork struct {
+	Handle   string       `json:"handle"`
+	Name     string       `json:"name"`
+	Country  string       `json:"country"`
+	Entities []rdapEntity `json:"entities"`
+}
+
+type rdapEntity struct {
+	Roles      []string        `json:"roles"`
+	VCardArray json.RawMessage `json:"vcardArray"`
+}
+
+// fn returns the formatted name from the entity's jCard, if any.
+func (e rdapEntity) fn() string {
+	// A jCard is ["vcard", [[name, params, type, value], ...]].
+	var card []json.RawMessage
+	if json.Unmarshal(e.VCardArray, &card) != nil || len(card) != 2 {
+		return ""
+	}
+	var props [][]json.RawMessage
+	if json.Unmarshal(card[1], &props) != nil {
+		return ""
+	}
+	for _, prop := range props {
+		var name, value string
+		if len(prop) < 4 || json.Unmarshal(prop[0], &name) != nil || name != "fn" {
+			continue
+		}
+		if json.Unmarshal(prop[3], &value) == nil {
+			return value
+		}
+	}
+	return ""
+}
+
+// org returns the name of the registrant, falling back to the first entity
+// that has a name at all.
+func (n *rdapNetwork) org() string {
+	var fallback string
+	for _, e := range n.Entities {
+		fn := e.fn()
+		if fn == "" {
+			continue
+		}
+		for _, role := range e.Roles {
+			if role == "registrant" {
+				return fn
+			}
+		}
+		if fallback == "" {
+			fallback = fn
+		}
+	}
+	return fallback
+}
+
+func (n *rdapNetwork) String() string {
+	var parts []string
+	for _, s := range []string{n.Name, n.org(), n.Country} {
+		if s != "" {
+			parts = append(parts, s)
+		}
+	}
+	if n.Handle != "" {
+		parts = append(parts, "("+n.Handle+")")
+	}
+	return strings.Join(parts, " ")
+}
+
+// lookupRDAP fetches the registration data of the network address belongs to.
+func lookupRDAP(address string) (*rdapNetwork, error) {
+	client := http.Client{Timeout: rdapTimeout}
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf(rdapAPI, strings.Trim(address, "[]")), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/rdap+json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	var network rdapNetwork
+	if err := json.NewDecoder(resp.Body).Decode(&network); err != nil {
+		return nil, err
+	}
+	return &network, nil
+}
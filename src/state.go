@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// savedState is what --state-file keeps of a run so that it can be resumed
+// after a restart or crash.
+type savedState struct {
+	Saved   time.Time     `json:"saved"`
+	Targets []savedTarget `json:"targets"`
+}
+
+type savedTarget struct {
+	Target      string       `json:"target"` // host and port as given
+	Stats       savedStats   `json:"stats"`
+	DNS         savedStats   `json:"dns"`
+	Outages     savedOutages `json:"outages"`
+	Bucket      savedStats   `json:"bucket"`
+	BucketStart time.Time    `json:"bucket_start"`
+	Buckets     []aggregate  `json:"buckets,omitempty"`
+}
+
+type savedStats struct {
+	Sent       int                   `json:"sent"`
+	Responded  int                   `json:"responded"`
+	Mismatched int                   `json:"mismatched"`
+	Min        time.Duration         `json:"min_ns"`
+	Max        time.Duration         `json:"max_ns"`
+	Total      time.Duration         `json:"total_ns"`
+	Histogram  map[time.Duration]int `json:"histogram_ns,omitempty"`
+}
+
+type savedOutages struct {
+	First      time.Time     `json:"first"`
+	Last       time.Time     `json:"last"`
+	Current    *outage       `json:"current,omitempty"`
+	Outages    []outage      `json:"outages"`
+	Unobserved time.Duration `json:"unobserved_ns"`
+}
+
+func saveStats(s statistics) savedStats {
+	return savedStats{
+		Sent:       s.sentCount,
+		Responded:  s.respondedCount,
+		Mismatched: s.mismatchedCount,
+		Min:        s.minTime,
+		Max:        s.maxTime,
+		Total:      s.totalResponseTime,
+		Histogram:  s.histogram,
+	}
+}
+
+func (s savedStats) statistics() statistics {
+	return statistics{
+		sentCount:         s.Sent,
+		respondedCount:    s.Responded,
+		mismatchedCount:   s.Mismatched,
+		minTime:           s.Min,
+		maxTime:           s.Max,
+		totalResponseTime: s.Total,
+		histogram:         s.Histogram,
+	}
+}
+
+// writeStateFile checkpoints the counters, outages and --aggregate buckets of
+// all targets to path.
+func writeStateFile(path string, targets []*target) error {
+	data, err := encodeState(targets)
+	if err != nil {
+		return err
+	}
+	return writeStateData(path, data)
+}
+
+// encodeState snapshots the state of all targets. Only counters and the
+// bounded RTT histograms are saved, so a checkpoint stays small however long
+// tcping has been running.
+func encodeState(targets []*target) ([]byte, error) {
+	state := savedState{Saved: time.Now()}
+	for _, t := range targets {
+		o := &t.outages
+		state.Targets = append(state.Targets, savedTarget{
+			Target: targetName(t),
+			Stats:  saveStats(t.stats),
+			DNS:    saveStats(t.dns),
+			Outages: savedOutages{
+				First:      o.first,
+				Last:       o.last,
+				Current:    o.current,
+				Outages:    o.outages,
+				Unobserved: o.unobserved,
+			},
+			Bucket:      saveStats(t.bucket),
+			BucketStart: t.bucketStart,
+			Buckets:     t.buckets,
+		})
+	}
+
+	return json.Marshal(state)
+}
+
+// writeStateData replaces the file at path atomically, so a crash while
+// writing leaves the previous checkpoint intact.
+func writeStateData(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// loadStateFile resumes the targets from the checkpoint at path, if there is
+// one. Targets are matched by host and port, and by address family for
+// --compare-family; targets that are not in the file start from scratch. An
+// outage that was ongoing when the checkpoint was written ends at its last
+// ping, and the time until the first ping of this run counts as not observed
+// rather than as available.
+func loadStateFile(path string, targets []*target) error {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var state savedState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("invalid state file %s: %v", path, err)
+	}
+
+	saved := make(map[string]*savedTarget)
+	for i := range state.Targets {
+		saved[state.Targets[i].Target] = &state.Targets[i]
+	}
+	for _, t := range targets {
+		st, ok := saved[targetName(t)]
+		if !ok {
+			continue
+		}
+		t.stats = st.Stats.statistics()
+		t.dns = st.DNS.statistics()
+		t.bucket = st.Bucket.statistics()
+		t.bucketStart = st.BucketStart
+		t.buckets = st.Buckets
+
+		o := outageTracker{
+			first:      st.Outages.First,
+			last:       st.Outages.Last,
+			outages:    st.Outages.Outages,
+			unobserved: st.Outages.Unobserved,
+			resumed:    !st.Outages.Last.IsZero(),
+		}
+		if st.Outages.Current != nil {
+			st.Outages.Current.End = o.last
+			o.outages = append(o.outages, *st.Outages.Current)
+		}
+		t.outages = o
+
+		fmt.Printf("Resumed %s from %s: %d pings since %s\n", t, path, t.stats.sentCount,
+			o.first.Format("2006-01-02 15:04:05"))
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStateFileRoundTrip(t *testing.T) {
+	v4 := &target{host: "example.com", version: "ipv4", perFamily: true, address: "192.0.2.1", port: "443"}
+	v6 := &target{host: "example.com", version: "ipv6", perFamily: true, address: "[2001:db8::1]", port: "443"}
+	start := time.Date(2026, 10, 14, 10, 0, 0, 0, time.UTC)
+	for i := 0; i < 4; i++ {
+		at := start.Add(time.Duration(i) * time.Second)
+		v4.stats.add(time.Duration(10+i)*time.Millisecond, nil)
+		v4.outages.record(at, true)
+		v6.stats.add(0, errors.New("timeout"))
+		v6.outages.record(at, false)
+	}
+
+	path := filepath.Join(t.TempDir(), "state.json")
+	if err := writeStateFile(path, []*target{v4, v6}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The targets of a new run, listed the other way round.
+	r6 := &target{host: "example.com", version: "ipv6", perFamily: true, address: "[2001:db8::1]", port: "443"}
+	r4 := &target{host: "example.com", version: "ipv4", perFamily: true, address: "192.0.2.1", port: "443"}
+	other := &target{host: "example.org", address: "192.0.2.9", port: "443"}
+	if err := loadStateFile(path, []*target{r6, r4, other}); err != nil {
+		t.Fatal(err)
+	}
+
+	if r4.stats.sentCount != 4 || r4.stats.respondedCount != 4 || r4.stats.median() != v4.stats.median() {
+		t.Errorf("ipv4 resumed with %d sent, %d responded, median %s", r4.stats.sentCount, r4.stats.respondedCount, r4.stats.median())
+	}
+	if r6.stats.sentCount != 4 || r6.stats.respondedCount != 0 {
+		t.Errorf("ipv6 resumed with %d sent, %d responded", r6.stats.sentCount, r6.stats.respondedCount)
+	}
+	// The outage that was ongoing ends at the last ping of the checkpoint.
+	if len(r6.outages.outages) != 1 || !r6.outages.outages[0].Start.Equal(start) || !r6.outages.outages[0].End.Equal(start.Add(3*time.Second)) {
+		t.Errorf("ipv6 resumed with outages %+v", r6.outages.outages)
+	}
+	if len(r4.outages.outages) != 0 {
+		t.Errorf("ipv4 resumed with outages %+v", r4.outages.outages)
+	}
+	if other.stats.sentCount != 0 {
+		t.Errorf("a target not in the state file resumed with %d sent", other.stats.sentCount)
+	}
+}
+
+func TestLoadStateFileMissing(t *testing.T) {
+	tg := &target{host: "example.com", address: "192.0.2.1", port: "443"}
+	if err := loadStateFile(filepath.Join(t.TempDir(), "missing.json"), []*target{tg}); err != nil {
+		t.Errorf("loadStateFile() of a missing file failed: %v", err)
+	}
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// statistics accumulates the results of the probes sent to one target.
+type statistics struct {
+	sentCount         int
+	respondedCount    int
+	minTime           time.Duration
+	maxTime           time.Duration
+	totalResponseTime time.Duration
+
+	// mismatchedCount counts the failed probes that did get a response, but
+	// not the one expected by --expect-status or --expect-body.
+	mismatchedCount int
+
+	// histogram counts the RTTs of the responses, rounded by sampleBucket,
+	// for the median, percentiles and mode. Unlike the RTTs themselves it
+	// stays small however long tcping runs.
+	histogram map[time.Duration]int
+}
+
+// sampleBucket rounds d to four significant digits, which bounds a histogram
+// to 9000 buckets per power of ten while keeping the median and percentiles
+// well within the printed precision.
+func sampleBucket(d time.Duration) time.Duration {
+	width := time.Duration(1)
+	for v := d; v >= 10000; v /= 10 {
+		width *= 10
+	}
+	return d.Round(width)
+}
+
+// add records the outcome of a single probe.
+func (s *statistics) add(elapsed time.Duration, err error) {
+	s.sentCount++
+	if err != nil {
+		if _, ok := err.(*mismatchError); ok {
+			s.mismatchedCount++
+		}
+		return
+	}
+	s.respondedCount++
+	if s.respondedCount == 1 || elapsed < s.minTime {
+		s.minTime = elapsed
+	}
+	if elapsed > s.maxTime {
+		s.maxTime = elapsed
+	}
+	s.totalResponseTime += elapsed
+	if s.histogram == nil {
+		s.histogram = make(map[time.Duration]int)
+	}
+	s.histogram[sampleBucket(elapsed)]++
+}
+
+// merge folds the results of another target into s.
+func (s *statistics) merge(o statistics) {
+	if o.respondedCount > 0 {
+		if s.respondedCount == 0 || o.minTime < s.minTime {
+			s.minTime = o.minTime
+		}
+		if o.maxTime > s.maxTime {
+			s.maxTime = o.maxTime
+		}
+	}
+	s.sentCount += o.sentCount
+	s.respondedCount += o.respondedCount
+	s.mismatchedCount += o.mismatchedCount
+	s.totalResponseTime += o.totalResponseTime
+	if len(o.histogram) > 0 && s.histogram == nil {
+		s.histogram = make(map[time.Duration]int)
+	}
+	for v, n := range o.histogram {
+		s.histogram[v] += n
+	}
+}
+
+func (s *statistics) loss() float64 {
+	return float64(s.sentCount-s.respondedCount) / float64(s.sentCount) * 100
+}
+
+// avg returns the average RTT. It must only be called when at least one
+// response was received.
+func (s *statistics) avg() time.Duration {
+	return s.totalResponseTime / time.Duration(s.respondedCount)
+}
+
+// sortedSamples returns the distinct RTTs of the histogram in ascending
+// order.
+func (s *statistics) sortedSamples() []time.Duration {
+	sorted := make([]time.Duration, 0, len(s.histogram))
+	for v := range s.histogram {
+		sorted = append(sorted, v)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted
+}
+
+// nth returns the RTT at 0-based rank k among the sorted responses.
+func (s *statistics) nth(sorted []time.Duration, k int) time.Duration {
+	for _, v := range sorted {
+		if k < s.histogram[v] {
+			return v
+		}
+		k -= s.histogram[v]
+	}
+	return s.maxTime
+}
+
+// median returns the median RTT. It must only be called when at least one
+// response was received.
+func (s *statistics) median() time.Duration {
+	return s.medianOf(s.sortedSamples())
+}
+
+// medianOf is median for the result of sortedSamples, so that several
+// percentiles can be taken from one sort.
+func (s *statistics) medianOf(sorted []time.Duration) time.Duration {
+	mid := s.respondedCount / 2
+	if s.respondedCount%2 == 0 {
+		return (s.nth(sorted, mid-1) + s.nth(sorted, mid)) / 2
+	}
+	return s.nth(sorted, mid)
+}
+
+// percentile returns the RTT below which p percent of the responses fall,
+// using the nearest-rank method. It must only be called when at least one
+// response was received.
+func (s *statistics) percentile(p float64) time.Duration {
+	return s.percentileOf(s.sortedSamples(), p)
+}
+
+func (s *statistics) percentileOf(sorted []time.Duration, p float64) time.Duration {
+	rank := int(math.Ceil(p / 100 * float64(s.respondedCount)))
+	if rank < 1 {
+		rank = 1
+	}
+	return s.nth(sorted, rank-1)
+}
+
+// mode returns the most common RTT and how often it was seen. RTTs are
+// bucketed at the printed precision, so with the default of whole
+// milliseconds that is 1ms buckets. Ties go to the lower RTT.
+func (s *statistics) mode() (time.Duration, int) {
+	width := rttResolution()
+	counts := make(map[time.Duration]int)
+	var mode time.Duration
+	var best int
+	for _, v := range s.sortedSamples() {
+		bucket := v.Round(width)
+		counts[bucket] += s.histogram[v]
+		if c := counts[bucket]; c > best || (c == best && bucket < mode) {
+			mode, best = bucket, c
+		}
+	}
+	return mode, best
+}
+
+// movingAverage is a simple moving average over the last N RTTs.
+type movingAverage struct {
+	samples []time.Duration
+	next    int
+	count   int
+	sum     time.Duration
+}
+
+func newMovingAverage(n int) *movingAverage {
+	return &movingAverage{samples: make([]time.Duration, n)}
+}
+
+// add records elapsed and returns the average of the retained samples.
+func (m *movingAverage) add(elapsed time.Duration) time.Duration {
+	if m.count == len(m.samples) {
+		m.sum -= m.samples[m.next]
+	} else {
+		m.count++
+	}
+	m.samples[m.next] = elapsed
+	m.sum += elapsed
+	m.next = (m.next + 1) % len(m.samples)
+	return m.sum / time.Duration(m.count)
+}
+
+// formatDelta formats the change from the previous RTT with a trend marker.
+func formatDelta(delta time.Duration) string {
+	switch {
+	case delta > 0:
+		return formatRTTDelta(delta) + " ▲"
+	case delta < 0:
+		return formatRTTDelta(delta) + " ▼"
+	default:
+		return formatRTT(0)
+	}
+}
+
+// printStatistics prints the summary for every target, followed by a
+// combined roll-up when more than one target was probed.
+func printStatistics(targets []*target) {
+	if len(targets) == 1 {
+		printTcpingStatistics("--- Tcping Statistics ---", targets[0].stats)
+		printOutages(&targets[0].outages)
+		printDNSStatistics(targets[0].dns)
+		return
+	}
+
+	var total, totalDNS statistics
+	for _, t := range targets {
+		printTcpingStatistics(fmt.Sprintf("--- Tcping Statistics for %s ---", t), t.stats)
+		printOutages(&t.outages)
+		printDNSStatistics(t.dns)
+		total.merge(t.stats)
+		totalDNS.merge(t.dns)
+	}
+	printTcpingStatistics(fmt.Sprintf("--- Tcping Statistics for all %d targets ---", len(targets)), total)
+	printDNSStatistics(totalDNS)
+}
+
+// printBurst prints the summary of one --burst of pings to t.
+func printBurst(t *target, s statistics) {
+	if s.respondedCount == 0 {
+		fmt.Printf("burst to %s: %d/%d responded\n", t, s.respondedCount, s.sentCount)
+		return
+	}
+	fmt.Printf("burst to %s: %d/%d responded, min/avg = %s/%s\n", t, s.respondedCount, s.sentCount, formatRTT(s.minTime), formatRTT(s.avg()))
+}
+
+// printDNSStatistics prints the resolution times recorded by --time-dns, if
+// any.
+func printDNSStatistics(s statistics) {
+	if s.sentCount == 0 {
+		return
+	}
+	fmt.Printf("%d dns lookups, %d failed\n", s.sentCount, s.sentCount-s.respondedCount)
+	if s.respondedCount > 0 {
+		fmt.Printf("dns min/avg/max = %s/%s/%s\n", formatRTT(s.minTime), formatRTT(s.avg()), formatRTT(s.maxTime))
+	}
+}
+
+func printTcpingStatistics(title string, s statistics) {
+	fmt.Println("")
+	fmt.Println(title)
+	if s.sentCount == 0 {
+		fmt.Println("No tcp ping sent.")
+		return
+	}
+	fmt.Printf("%d tcp ping sent, %d tcp ping responsed, %.2f%% loss\n", s.sentCount, s.respondedCount, s.loss())
+	if s.mismatchedCount > 0 {
+		fmt.Printf("%d of the lost pings got an unexpected response\n", s.mismatchedCount)
+	}
+	if s.respondedCount > 0 {
+		fmt.Printf("min/avg/max = %s/%s/%s\n", formatRTT(s.minTime), formatRTT(s.avg()), formatRTT(s.maxTime))
+		mode, count := s.mode()
+		fmt.Printf("median = %s, mode = %s (%d of %d)\n", formatRTT(s.median()), formatRTT(mode), count, s.respondedCount)
+	} else {
+		fmt.Println("No responses received.")
+	}
+}
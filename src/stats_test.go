@@ -0,0 +1,83 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSampleBucket(t *testing.T) {
+	tests := []struct {
+		d, want time.Duration
+	}{
+		{0, 0},
+		{9999, 9999},
+		{10004, 10000},
+		{10005, 10010},
+		{99994, 99990},
+		{99995, 100000},
+		{1234567, 1235000},
+		{1500 * time.Millisecond, 1500 * time.Millisecond},
+		{12345678901, 12350000000},
+	}
+	for _, tt := range tests {
+		if got := sampleBucket(tt.d); got != tt.want {
+			t.Errorf("sampleBucket(%d) = %d, want %d", tt.d, got, tt.want)
+		}
+	}
+}
+
+func TestStatisticsPercentiles(t *testing.T) {
+	ms := time.Millisecond
+	tests := []struct {
+		name      string
+		samples   []time.Duration
+		median    time.Duration
+		p50, p90  time.Duration
+		mode      time.Duration
+		modeCount int
+	}{
+		{"single sample", []time.Duration{7 * ms}, 7 * ms, 7 * ms, 7 * ms, 7 * ms, 1},
+		{"odd count", []time.Duration{3 * ms, 1 * ms, 2 * ms}, 2 * ms, 2 * ms, 3 * ms, 1 * ms, 1},
+		{"even count", []time.Duration{10 * ms, 1 * ms, 3 * ms, 2 * ms}, 2500 * time.Microsecond, 2 * ms, 10 * ms, 1 * ms, 1},
+		{"repeated RTT", []time.Duration{5 * ms, 9 * ms, 5 * ms, 5 * ms}, 5 * ms, 5 * ms, 9 * ms, 5 * ms, 3},
+		{"mode rounded to the printed precision", []time.Duration{1400 * time.Microsecond, 1600 * time.Microsecond, 1700 * time.Microsecond}, 1600 * time.Microsecond, 1600 * time.Microsecond, 1700 * time.Microsecond, 2 * ms, 2},
+		// The histogram keeps four significant digits, so these are taken
+		// as 9999ns and 10010ns.
+		{"even across a bucket width", []time.Duration{9999, 10005}, 10004, 9999, 10010, 0, 2},
+		// 99994ns, 99995ns and 1234567ns are held as 99990ns, 100000ns and
+		// 1235000ns.
+		{"odd across a power of ten", []time.Duration{1234567, 99995, 99994}, 100000, 100000, 1235000, 0, 2},
+		{"same bucket", []time.Duration{10001, 10014, 10004}, 10000, 10000, 10010, 0, 3},
+	}
+	for _, tt := range tests {
+		var whole, first, second statistics
+		for i, d := range tt.samples {
+			whole.add(d, nil)
+			if i%2 == 0 {
+				first.add(d, nil)
+			} else {
+				second.add(d, nil)
+			}
+		}
+		// Merging the results of two targets must not change them.
+		first.merge(second)
+
+		for _, s := range []struct {
+			how   string
+			stats *statistics
+		}{{"added", &whole}, {"merged", &first}} {
+			if got := s.stats.median(); got != tt.median {
+				t.Errorf("%s (%s): median() = %d, want %d", tt.name, s.how, got, tt.median)
+			}
+			if got := s.stats.percentile(50); got != tt.p50 {
+				t.Errorf("%s (%s): percentile(50) = %d, want %d", tt.name, s.how, got, tt.p50)
+			}
+			if got := s.stats.percentile(90); got != tt.p90 {
+				t.Errorf("%s (%s): percentile(90) = %d, want %d", tt.name, s.how, got, tt.p90)
+			}
+			if got, n := s.stats.mode(); got != tt.mode || n != tt.modeCount {
+				t.Errorf("%s (%s): mode() = %d x%d, want %d x%d", tt.name, s.how, got, n, tt.mode, tt.modeCount)
+			}
+		}
+	}
+}
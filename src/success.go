@@ -0,0 +1,353 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"unicode"
+)
+
+// bannerSize caps how much of a greeting is read for the banner variable.
+const bannerSize = 1024
+
+// probeValues are the variables a --success-if condition can refer to.
+type probeValues struct {
+	rtt    time.Duration
+	err    error
+	banner string
+	status int
+}
+
+// condition is a parsed --success-if expression such as
+// `rtt<100ms && banner=~"SSH-2.0"`.
+type condition interface {
+	eval(v *probeValues) bool
+}
+
+type andCondition struct{ a, b condition }
+type orCondition struct{ a, b condition }
+type notCondition struct{ c condition }
+
+func (c andCondition) eval(v *probeValues) bool { return c.a.eval(v) && c.b.eval(v) }
+func (c orCondition) eval(v *probeValues) bool  { return c.a.eval(v) || c.b.eval(v) }
+func (c notCondition) eval(v *probeValues) bool { return !c.c.eval(v) }
+
+// compareCondition compares one variable with a literal.
+type compareCondition struct {
+	name string
+	op   string
+	dur  time.Duration
+	num  int
+	str  string
+	re   *regexp.Regexp
+}
+
+func (c compareCondition) eval(v *probeValues) bool {
+	switch c.name {
+	case "rtt":
+		return compareOrdered(int64(v.rtt), int64(c.dur), c.op)
+	case "status":
+		return compareOrdered(int64(v.status), int64(c.num), c.op)
+	}
+
+	s := v.banner
+	if c.name == "error" {
+		s = errorClass(v.err)
+	}
+	switch c.op {
+	case "==":
+		return s == c.str
+	case "!=":
+		return s != c.str
+	case "=~":
+		return c.re.MatchString(s)
+	default: // "!~"
+		return !c.re.MatchString(s)
+	}
+}
+
+func compareOrdered(a, b int64, op string) bool {
+	switch op {
+	case "<":
+		return a < b
+	case "<=":
+		return a <= b
+	case ">":
+		return a > b
+	case ">=":
+		return a >= b
+	case "==":
+		return a == b
+	default: // "!="
+		return a != b
+	}
+}
+
+// errorClass sorts a probe error into the classes --success-if can test
+// for. A successful probe has the class "none".
+func errorClass(err error) string {
+	var nerr net.Error
+	var dnsErr *net.DNSError
+	switch {
+	case err == nil:
+		return "none"
+	case errors.As(err, new(*mismatchError)):
+		return "unexpected"
+	case errors.As(err, &dnsErr):
+		return "dns"
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "refused"
+	case errors.Is(err, syscall.ECONNRESET):
+		return "reset"
+	case errors.Is(err, syscall.EHOSTUNREACH), errors.Is(err, syscall.ENETUNREACH):
+		return "unreachable"
+	case errors.As(err, &nerr) && nerr.Timeout():
+		return "timeout"
+	case strings.HasPrefix(err.Error(), "TLS handshake failed"):
+		return "tls"
+	}
+	return "other"
+}
+
+// successProbe wraps probe so that its outcome is decided by cond instead.
+// A probe that failed but meets the condition, e.g. `error=="refused"` to
+// verify that a port is closed, counts as a success. A successful probe that
+// does not meet it counts as an unexpected response.
+func successProbe(cond condition, probe probeFunc) probeFunc {
+	return func(t *target, timeout time.Duration) (time.Duration, string, error) {
+		t.banner, t.httpStatus = "", 0
+		elapsed, detail, err := probe(t, timeout)
+		v := &probeValues{rtt: elapsed, err: err, banner: t.banner, status: t.httpStatus}
+		switch {
+		case !cond.eval(v) && err == nil:
+			return elapsed, detail, &mismatchError{"success condition not met"}
+		case !cond.eval(v):
+			return elapsed, detail, err
+		case err != nil && detail != "":
+			return elapsed, detail + ", " + errorClass(err), nil
+		case err != nil:
+			return elapsed, errorClass(err), nil
+		}
+		return elapsed, detail, nil
+	}
+}
+
+// bannerProbe connects like tcpProbe and then reads whatever the server
+// sends first, for the banner variable. It waits for the greeting until the
+// timeout; only the connect counts as the RTT.
+func bannerProbe(t *target, timeout time.Duration) (time.Duration, string, error) {
+	rateLimiter.wait()
+
+	start := time.Now()
+	conn, err := net.DialTimeout("tcp", t.String(), timeout)
+	elapsed := time.Since(start)
+	if err != nil {
+		return elapsed, "", err
+	}
+	defer conn.Close()
+
+	if timeout > 0 {
+		conn.SetReadDeadline(start.Add(timeout))
+	}
+	buf := make([]byte, bannerSize)
+	n, _ := conn.Read(buf)
+	t.banner = string(buf[:n])
+	if n == 0 {
+		return elapsed, "no banner", nil
+	}
+	first := strings.TrimRight(strings.SplitN(t.banner, "\n", 2)[0], "\r")
+	return elapsed, fmt.Sprintf("banner %q", first), nil
+}
+
+// usesBanner reports whether cond refers to the banner variable.
+func usesBanner(cond condition) bool {
+	switch c := cond.(type) {
+	case andCondition:
+		return usesBanner(c.a) || usesBanner(c.b)
+	case orCondition:
+		return usesBanner(c.a) || usesBanner(c.b)
+	case notCondition:
+		return usesBanner(c.c)
+	case compareCondition:
+		return c.name == "banner"
+	}
+	return false
+}
+
+// parseCondition parses a --success-if expression. Conditions compare a
+// variable with a literal and are combined with &&, || and !, e.g.
+//
+//	rtt<100ms && (error=="none" || error=="refused") && banner=~"^SSH-2.0"
+//
+// rtt takes a duration and status (the HTTP status code) a number, both with
+// <, <=, >, >=, == and !=. error and banner take a quoted string with == and
+// != or a quoted regular expression with =~ and !~.
+func parseCondition(s string) (condition, error) {
+	tokens, err := tokenize(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &conditionParser{tokens: tokens}
+	cond, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q in --success-if", p.tokens[p.pos])
+	}
+	return cond, nil
+}
+
+var conditionOperators = []string{"&&", "||", "<=", ">=", "==", "!=", "=~", "!~", "<", ">", "!", "(", ")"}
+
+func tokenize(s string) ([]string, error) {
+	var tokens []string
+	for i := 0; i < len(s); {
+		c := rune(s[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+			continue
+		case c == '"':
+			j := i + 1
+			for ; j < len(s) && s[j] != '"'; j++ {
+				if s[j] == '\\' {
+					j++
+				}
+			}
+			if j >= len(s) {
+				return nil, fmt.Errorf("unterminated string in --success-if")
+			}
+			tokens = append(tokens, s[i:j+1])
+			i = j + 1
+			continue
+		case unicode.IsLetter(c) || unicode.IsDigit(c) || c == '.':
+			j := i
+			for j < len(s) && (unicode.IsLetter(rune(s[j])) || unicode.IsDigit(rune(s[j])) || s[j] == '.' || s[j] == '_') {
+				j++
+			}
+			tokens = append(tokens, s[i:j])
+			i = j
+			continue
+		}
+		matched := false
+		for _, op := range conditionOperators {
+			if strings.HasPrefix(s[i:], op) {
+				tokens = append(tokens, op)
+				i += len(op)
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return nil, fmt.Errorf("unexpected %q in --success-if", s[i:i+1])
+		}
+	}
+	return tokens, nil
+}
+
+type conditionParser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *conditionParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *conditionParser) next() string {
+	tok := p.peek()
+	p.pos++
+	return tok
+}
+
+func (p *conditionParser) or() (condition, error) {
+	cond, err := p.and()
+	for err == nil && p.peek() == "||" {
+		p.next()
+		var b condition
+		if b, err = p.and(); err == nil {
+			cond = orCondition{cond, b}
+		}
+	}
+	return cond, err
+}
+
+func (p *conditionParser) and() (condition, error) {
+	cond, err := p.unary()
+	for err == nil && p.peek() == "&&" {
+		p.next()
+		var b condition
+		if b, err = p.unary(); err == nil {
+			cond = andCondition{cond, b}
+		}
+	}
+	return cond, err
+}
+
+func (p *conditionParser) unary() (condition, error) {
+	switch p.peek() {
+	case "!":
+		p.next()
+		cond, err := p.unary()
+		return notCondition{cond}, err
+	case "(":
+		p.next()
+		cond, err := p.or()
+		if err == nil && p.next() != ")" {
+			err = fmt.Errorf("missing ) in --success-if")
+		}
+		return cond, err
+	}
+	return p.comparison()
+}
+
+func (p *conditionParser) comparison() (condition, error) {
+	c := compareCondition{name: p.next(), op: p.next()}
+	literal := p.next()
+	if literal == "" {
+		return nil, fmt.Errorf("incomplete --success-if")
+	}
+
+	var err error
+	switch c.name {
+	case "rtt", "status":
+		switch c.op {
+		case "<", "<=", ">", ">=", "==", "!=":
+		default:
+			return nil, fmt.Errorf("%s does not support %q in --success-if", c.name, c.op)
+		}
+		if c.name == "rtt" {
+			c.dur, err = time.ParseDuration(literal)
+		} else {
+			c.num, err = strconv.Atoi(literal)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid value %s for %s in --success-if", literal, c.name)
+		}
+	case "error", "banner":
+		if c.str, err = strconv.Unquote(literal); err != nil || literal[0] != '"' {
+			return nil, fmt.Errorf("%s must be compared with a quoted string in --success-if", c.name)
+		}
+		switch c.op {
+		case "==", "!=":
+		case "=~", "!~":
+			if c.re, err = regexp.Compile(c.str); err != nil {
+				return nil, fmt.Errorf("invalid regular expression in --success-if: %v", err)
+			}
+		default:
+			return nil, fmt.Errorf("%s does not support %q in --success-if", c.name, c.op)
+		}
+	default:
+		return nil, fmt.Errorf("unknown variable %q in --success-if, expected rtt, status, error or banner", c.name)
+	}
+	return c, nil
+}
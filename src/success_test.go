@@ -0,0 +1,117 @@
+package main
+
+import (
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"rtt<100ms", []string{"rtt", "<", "100ms"}},
+		{"rtt <= 1.5s", []string{"rtt", "<=", "1.5s"}},
+		{`error=="refused"||!(banner=~"^SSH")`, []string{"error", "==", `"refused"`, "||", "!", "(", "banner", "=~", `"^SSH"`, ")"}},
+		{`banner=="a \"b\" c"`, []string{"banner", "==", `"a \"b\" c"`}},
+		{"status!=404&&status>=200", []string{"status", "!=", "404", "&&", "status", ">=", "200"}},
+		{"  ", nil},
+	}
+	for _, tt := range tests {
+		got, err := tokenize(tt.in)
+		if err != nil {
+			t.Errorf("tokenize(%q) failed: %v", tt.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("tokenize(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+
+	for _, in := range []string{`banner=="open`, "rtt<100ms & status==200", "rtt#1"} {
+		if _, err := tokenize(in); err == nil {
+			t.Errorf("tokenize(%q) succeeded, want an error", in)
+		}
+	}
+}
+
+func TestParseCondition(t *testing.T) {
+	ok := &probeValues{rtt: 50 * time.Millisecond, banner: "SSH-2.0-OpenSSH_9.6", status: 200}
+	slow := &probeValues{rtt: 300 * time.Millisecond, status: 503}
+	refused := &probeValues{err: syscall.ECONNREFUSED}
+
+	tests := []struct {
+		in   string
+		v    *probeValues
+		want bool
+	}{
+		{"rtt<100ms", ok, true},
+		{"rtt<100ms", slow, false},
+		{"rtt>=300ms", slow, true},
+		{"status==200", ok, true},
+		{"status!=200", slow, true},
+		{`error=="none"`, ok, true},
+		{`error=="refused"`, refused, true},
+		{`error!="refused"`, refused, false},
+		{`banner=~"^SSH-2\\.0"`, ok, true},
+		{`banner!~"^SSH"`, ok, false},
+		{`rtt<100ms && status==200`, slow, false},
+		{`rtt<100ms || status==503`, slow, true},
+		{`!(rtt<100ms)`, slow, true},
+		{`!rtt<100ms && status==200`, ok, false},
+		// && binds tighter than ||.
+		{`status==503 || status==200 && rtt<100ms`, slow, true},
+		{`(status==503 || status==200) && rtt<100ms`, slow, false},
+	}
+	for _, tt := range tests {
+		cond, err := parseCondition(tt.in)
+		if err != nil {
+			t.Errorf("parseCondition(%q) failed: %v", tt.in, err)
+			continue
+		}
+		if got := cond.eval(tt.v); got != tt.want {
+			t.Errorf("parseCondition(%q).eval(%+v) = %v, want %v", tt.in, *tt.v, got, tt.want)
+		}
+	}
+
+	for _, in := range []string{
+		"",
+		"rtt<",
+		"rtt<fast",
+		"rtt=~\"1ms\"",
+		"status<2xx",
+		"banner==SSH",
+		`banner<"SSH"`,
+		`banner=~"("`,
+		"latency<100ms",
+		"(rtt<100ms",
+		"rtt<100ms)",
+		"rtt<100ms status==200",
+	} {
+		if _, err := parseCondition(in); err == nil {
+			t.Errorf("parseCondition(%q) succeeded, want an error", in)
+		}
+	}
+}
+
+func TestUsesBanner(t *testing.T) {
+	tests := []struct {
+		in   string
+		want bool
+	}{
+		{"rtt<100ms", false},
+		{`banner=~"SSH"`, true},
+		{`rtt<100ms && !(status==200 || banner=="x")`, true},
+	}
+	for _, tt := range tests {
+		cond, err := parseCondition(tt.in)
+		if err != nil {
+			t.Fatalf("parseCondition(%q) failed: %v", tt.in, err)
+		}
+		if got := usesBanner(cond); got != tt.want {
+			t.Errorf("usesBanner(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
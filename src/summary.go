@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+)
+
+// summaryReport is the machine-readable form of the final statistics written
+// by --summary-file.
+type summaryReport struct {
+	Start   time.Time       `json:"start"`
+	End     time.Time       `json:"end"`
+	Targets []targetSummary `json:"targets"`
+	Total   *statsSummary   `json:"total,omitempty"`
+}
+
+type targetSummary struct {
+	Target   string `json:"target"`
+	Host     string `json:"host"`
+	Hostname string `json:"hostname,omitempty"`
+	statsSummary
+	Availability float64       `json:"availability"`
+	Outages      []outage      `json:"outages,omitempty"`
+	DNS          *statsSummary `json:"dns,omitempty"`
+	Geo          *geoInfo      `json:"geo,omitempty"`
+	Buckets      []aggregate   `json:"buckets,omitempty"`
+}
+
+type statsSummary struct {
+	Sent       int             `json:"sent"`
+	Responded  int             `json:"responded"`
+	Loss       float64         `json:"loss"`
+	Unexpected int             `json:"unexpected_responses,omitempty"`
+	Latency    *latencySummary `json:"latency_ms,omitempty"`
+}
+
+type latencySummary struct {
+	Min    float64 `json:"min"`
+	Avg    float64 `json:"avg"`
+	Max    float64 `json:"max"`
+	Median float64 `json:"median"`
+	P90    float64 `json:"p90"`
+	P95    float64 `json:"p95"`
+	P99    float64 `json:"p99"`
+}
+
+func summarize(s statistics) statsSummary {
+	sum := statsSummary{Sent: s.sentCount, Responded: s.respondedCount, Unexpected: s.mismatchedCount}
+	if s.sentCount > 0 {
+		sum.Loss = s.loss()
+	}
+	if s.respondedCount > 0 {
+		sorted := s.sortedSamples()
+		sum.Latency = &latencySummary{
+			Min:    milliseconds(s.minTime),
+			Avg:    milliseconds(s.avg()),
+			Max:    milliseconds(s.maxTime),
+			Median: milliseconds(s.medianOf(sorted)),
+			P90:    milliseconds(s.percentileOf(sorted, 90)),
+			P95:    milliseconds(s.percentileOf(sorted, 95)),
+			P99:    milliseconds(s.percentileOf(sorted, 99)),
+		}
+	}
+	return sum
+}
+
+// writeSummaryFile writes the final statistics of all targets to path as
+// JSON, including a combined total when there is more than one target.
+func writeSummaryFile(path string, targets []*target, start time.Time) error {
+	report := summaryReport{Start: start, End: time.Now()}
+
+	var total statistics
+	for _, t := range targets {
+		ts := targetSummary{
+			Target:       t.String(),
+			Host:         t.host,
+			Hostname:     t.hostname,
+			statsSummary: summarize(t.stats),
+			Availability: t.outages.availability(),
+			Outages:      t.outages.outages,
+			Geo:          t.geo,
+			Buckets:      t.buckets,
+		}
+		if t.dns.sentCount > 0 {
+			dns := summarize(t.dns)
+			ts.DNS = &dns
+		}
+		report.Targets = append(report.Targets, ts)
+		total.merge(t.stats)
+	}
+	if len(targets) > 1 {
+		sum := summarize(total)
+		report.Total = &sum
+	}
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
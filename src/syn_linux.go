@@ -0,0 +1,197 @@
+//go:build linux
+
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// TCP header flags.
+const (
+	tcpFlagSYN = 0x02
+	tcpFlagRST = 0x04
+	tcpFlagACK = 0x10
+)
+
+// newSYNProbe returns a probe that sends a bare SYN from a raw socket and
+// times the SYN-ACK, never completing the handshake; the kernel answers the
+// SYN-ACK with a RST since no socket owns the connection. Raw sockets need
+// root or CAP_NET_RAW, which is checked here.
+func newSYNProbe() (probeFunc, error) {
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_RAW, syscall.IPPROTO_TCP)
+	if err != nil {
+		return nil, fmt.Errorf("--syn needs root or CAP_NET_RAW: %v", err)
+	}
+	syscall.Close(fd)
+	return synProbe, nil
+}
+
+func synProbe(t *target, timeout time.Duration) (time.Duration, string, error) {
+	rateLimiter.wait()
+
+	dst := net.ParseIP(strings.Trim(t.address, "[]"))
+	port, _ := strconv.Atoi(t.port)
+	src, err := sourceAddress(t)
+	if err != nil {
+		return 0, "", err
+	}
+
+	family := syscall.AF_INET
+	if dst.To4() == nil {
+		family = syscall.AF_INET6
+	}
+	fd, err := syscall.Socket(family, syscall.SOCK_RAW, syscall.IPPROTO_TCP)
+	if err != nil {
+		return 0, "", err
+	}
+	defer syscall.Close(fd)
+
+	var sa syscall.Sockaddr
+	if family == syscall.AF_INET {
+		sa4 := &syscall.SockaddrInet4{}
+		copy(sa4.Addr[:], dst.To4())
+		sa = sa4
+	} else {
+		sa6 := &syscall.SockaddrInet6{}
+		copy(sa6.Addr[:], dst.To16())
+		sa = sa6
+	}
+
+	srcPort := uint16(32768 + rand.Intn(28232))
+	seq := rand.Uint32()
+	packet := synPacket(src, dst, srcPort, uint16(port), seq)
+
+	start := time.Now()
+	if err := syscall.Sendto(fd, packet, 0, sa); err != nil {
+		return 0, "", err
+	}
+
+	buf := make([]byte, 1500)
+	for {
+		// As for a TCP connect, a timeout of 0 waits as long as it takes,
+		// which a zero SO_RCVTIMEO does too.
+		var tv syscall.Timeval
+		if timeout > 0 {
+			left := time.Until(start.Add(timeout))
+			if left <= 0 {
+				return time.Since(start), "", synTimeout{}
+			}
+			if tv = syscall.NsecToTimeval(left.Nanoseconds()); tv.Sec == 0 && tv.Usec == 0 {
+				tv.Usec = 1
+			}
+		}
+		syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv)
+		n, from, err := syscall.Recvfrom(fd, buf, 0)
+		elapsed := time.Since(start)
+		if err == syscall.EAGAIN || err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			return elapsed, "", err
+		}
+
+		tcp := buf[:n]
+		if family == syscall.AF_INET {
+			var ok bool
+			if tcp, ok = ipv4Payload(buf[:n], dst); !ok {
+				continue
+			}
+		} else if from6, ok := from.(*syscall.SockaddrInet6); !ok || !net.IP(from6.Addr[:]).Equal(dst) {
+			continue
+		}
+		if len(tcp) < 20 ||
+			binary.BigEndian.Uint16(tcp[0:2]) != uint16(port) ||
+			binary.BigEndian.Uint16(tcp[2:4]) != srcPort ||
+			binary.BigEndian.Uint32(tcp[8:12]) != seq+1 {
+			continue
+		}
+
+		flags := tcp[13]
+		switch {
+		case flags&tcpFlagRST != 0:
+			return elapsed, "", syscall.ECONNREFUSED
+		case flags&(tcpFlagSYN|tcpFlagACK) == tcpFlagSYN|tcpFlagACK:
+			return elapsed, "SYN-ACK", nil
+		}
+	}
+}
+
+// ipv4Payload returns what follows the IP header of a packet from an IPv4
+// raw socket, which delivers the header too, if the packet came from src.
+func ipv4Payload(packet []byte, src net.IP) ([]byte, bool) {
+	if len(packet) < 20 {
+		return nil, false
+	}
+	ihl := int(packet[0]&0x0f) * 4
+	if ihl < 20 || ihl > len(packet) || !net.IP(packet[12:16]).Equal(src) {
+		return nil, false
+	}
+	return packet[ihl:], true
+}
+
+// synTimeout is the error for a SYN that got no answer in time. Like the
+// errors of a dial, it reports itself as a timeout.
+type synTimeout struct{}
+
+func (synTimeout) Error() string   { return "no SYN-ACK received: i/o timeout" }
+func (synTimeout) Timeout() bool   { return true }
+func (synTimeout) Temporary() bool { return true }
+
+// sourceAddress returns the local address the kernel would use to reach t,
+// which is part of the TCP checksum.
+func sourceAddress(t *target) (net.IP, error) {
+	conn, err := net.Dial("udp", t.String())
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP, nil
+}
+
+// synPacket builds a TCP header with only SYN set.
+func synPacket(src, dst net.IP, srcPort, dstPort uint16, seq uint32) []byte {
+	tcp := make([]byte, 20)
+	binary.BigEndian.PutUint16(tcp[0:2], srcPort)
+	binary.BigEndian.PutUint16(tcp[2:4], dstPort)
+	binary.BigEndian.PutUint32(tcp[4:8], seq)
+	tcp[12] = 5 << 4 // header length in 32 bit words
+	tcp[13] = tcpFlagSYN
+	binary.BigEndian.PutUint16(tcp[14:16], 65535) // window
+
+	// The checksum covers a pseudo header with both addresses.
+	var pseudo []byte
+	if src4, dst4 := src.To4(), dst.To4(); src4 != nil && dst4 != nil {
+		pseudo = append(pseudo, src4...)
+		pseudo = append(pseudo, dst4...)
+		pseudo = append(pseudo, 0, syscall.IPPROTO_TCP, 0, byte(len(tcp)))
+	} else {
+		pseudo = append(pseudo, src.To16()...)
+		pseudo = append(pseudo, dst.To16()...)
+		pseudo = append(pseudo, 0, 0, 0, byte(len(tcp)), 0, 0, 0, syscall.IPPROTO_TCP)
+	}
+	pseudo = append(pseudo, tcp...)
+	binary.BigEndian.PutUint16(tcp[16:18], checksum(pseudo))
+	return tcp
+}
+
+// checksum is the Internet checksum of RFC 1071.
+func checksum(b []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(b[i])<<8 | uint32(b[i+1])
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+	return ^uint16(sum)
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net"
+	"testing"
+)
+
+func TestIPv4Payload(t *testing.T) {
+	src := net.ParseIP("192.0.2.1")
+	header := func(ihl byte, from string, size int) []byte {
+		p := make([]byte, size)
+		p[0] = 0x40 | ihl
+		copy(p[12:16], net.ParseIP(from).To4())
+		return p
+	}
+
+	tests := []struct {
+		name   string
+		packet []byte
+		want   int // length of the payload, -1 if the packet is skipped
+	}{
+		{"plain header", header(5, "192.0.2.1", 40), 20},
+		{"with options", header(6, "192.0.2.1", 44), 20},
+		{"other source", header(5, "192.0.2.2", 40), -1},
+		{"short", make([]byte, 19), -1},
+		{"header only", header(5, "192.0.2.1", 20), 0},
+		{"ihl past the end", header(15, "192.0.2.1", 40), -1},
+		{"ihl too small", header(4, "192.0.2.1", 40), -1},
+	}
+	for _, tt := range tests {
+		payload, ok := ipv4Payload(tt.packet, src)
+		got := len(payload)
+		if !ok {
+			got = -1
+		}
+		if got != tt.want {
+			t.Errorf("%s: ipv4Payload() returned %d bytes, want %d", tt.name, got, tt.want)
+		}
+	}
+}
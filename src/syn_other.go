@@ -0,0 +1,11 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// newSYNProbe is not supported on this platform, so --syn falls back to a
+// full connect.
+func newSYNProbe() (probeFunc, error) {
+	return nil, errors.New("--syn is only supported on Linux")
+}
@@ -0,0 +1,11 @@
+//go:build !linux && !darwin
+
+package main
+
+import "errors"
+
+// makeCbreak is not supported on this platform, so key controls are
+// disabled.
+func makeCbreak(fd int) (func(), error) {
+	return nil, errors.New("key controls are not supported on this platform")
+}
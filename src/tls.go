@@ -0,0 +1,252 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// tlsOptions are the --tls related flags.
+type tlsOptions struct {
+	sni      string
+	min      string
+	max      string
+	ciphers  string
+	cert     string
+	key      string
+	caFile   string
+	insecure bool
+	alpn     string
+	ocsp     bool
+}
+
+// tlsProber completes a TLS handshake on top of the TCP connect, reporting
+// the connect and handshake times separately. The RTT recorded in the
+// statistics is the sum of both.
+type tlsProber struct {
+	sni    string
+	config *tls.Config
+	cert   *tls.Certificate
+	roots  *x509.CertPool
+	ocsp   bool
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+func newTLSProber(opts tlsOptions) (*tlsProber, error) {
+	config := &tls.Config{}
+
+	if opts.min != "" {
+		v, ok := tlsVersions[opts.min]
+		if !ok {
+			return nil, fmt.Errorf("unknown TLS version %q for --tls-min", opts.min)
+		}
+		config.MinVersion = v
+	}
+	if opts.max != "" {
+		v, ok := tlsVersions[opts.max]
+		if !ok {
+			return nil, fmt.Errorf("unknown TLS version %q for --tls-max", opts.max)
+		}
+		config.MaxVersion = v
+	}
+	if config.MinVersion != 0 && config.MaxVersion != 0 && config.MinVersion > config.MaxVersion {
+		return nil, fmt.Errorf("--tls-min cannot be higher than --tls-max")
+	}
+
+	if opts.ciphers != "" {
+		suites, err := parseCipherSuites(opts.ciphers)
+		if err != nil {
+			return nil, err
+		}
+		config.CipherSuites = suites
+	}
+
+	if opts.alpn != "" {
+		for _, proto := range strings.Split(opts.alpn, ",") {
+			config.NextProtos = append(config.NextProtos, strings.TrimSpace(proto))
+		}
+	}
+
+	p := &tlsProber{sni: opts.sni, config: config, ocsp: opts.ocsp}
+	if opts.cert != "" || opts.key != "" {
+		if opts.cert == "" || opts.key == "" {
+			return nil, fmt.Errorf("--cert and --key must be given together")
+		}
+		cert, err := tls.LoadX509KeyPair(opts.cert, opts.key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %v", err)
+		}
+		p.cert = &cert
+	}
+	if opts.caFile != "" {
+		pem, err := os.ReadFile(opts.caFile)
+		if err != nil {
+			return nil, err
+		}
+		p.roots = x509.NewCertPool()
+		if !p.roots.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", opts.caFile)
+		}
+		config.RootCAs = p.roots
+	}
+	// With --insecure the handshake never fails on the certificate; it is
+	// verified separately afterwards and the result reported instead.
+	config.InsecureSkipVerify = opts.insecure
+	return p, nil
+}
+
+// parseCipherSuites parses a comma separated list of cipher suite names as
+// used by Go, e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256. TLS 1.3 suites are
+// rejected since they cannot be configured.
+func parseCipherSuites(list string) ([]uint16, error) {
+	known := make(map[string]*tls.CipherSuite)
+	for _, cs := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+		known[cs.Name] = cs
+	}
+
+	var suites []uint16
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		cs, ok := known[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown cipher suite %q", name)
+		}
+		if len(cs.SupportedVersions) == 1 && cs.SupportedVersions[0] == tls.VersionTLS13 {
+			return nil, fmt.Errorf("cipher suite %s is TLS 1.3 only and cannot be configured", name)
+		}
+		suites = append(suites, cs.ID)
+	}
+	return suites, nil
+}
+
+func tlsVersionName(v uint16) string {
+	for name, id := range tlsVersions {
+		if id == v {
+			return "TLS " + name
+		}
+	}
+	return fmt.Sprintf("TLS 0x%04x", v)
+}
+
+// serverName returns the name sent as SNI and verified against the
+// certificate: the --sni override, or the host given on the command line.
+// IP addresses are not sent as SNI.
+func (p *tlsProber) serverName(t *target) string {
+	if p.sni != "" {
+		return p.sni
+	}
+	return t.host
+}
+
+func (p *tlsProber) probe(t *target, timeout time.Duration) (time.Duration, string, error) {
+	rateLimiter.wait()
+
+	start := time.Now()
+	conn, err := net.DialTimeout("tcp", t.String(), timeout)
+	connected := time.Since(start)
+	if err != nil {
+		return connected, "", err
+	}
+	defer conn.Close()
+
+	if timeout > 0 {
+		conn.SetDeadline(start.Add(timeout))
+	}
+	client, requested, err := p.handshake(conn, p.serverName(t))
+	elapsed := time.Since(start)
+	if err != nil {
+		return elapsed, "", err
+	}
+	detail, err := p.describe(client, requested, timeout)
+	if err != nil {
+		return elapsed, "", err
+	}
+	return elapsed, fmt.Sprintf("connect %s, handshake %s, %s",
+		formatRTT(connected), formatRTT(elapsed-connected), detail), nil
+}
+
+// handshake completes a TLS handshake with serverName over conn. It also
+// reports whether the server asked for the client certificate.
+func (p *tlsProber) handshake(conn net.Conn, serverName string) (*tls.Conn, bool, error) {
+	config := p.config.Clone()
+	config.ServerName = serverName
+	requested := false
+	if p.cert != nil {
+		config.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			requested = true
+			return p.cert, nil
+		}
+	}
+	client := tls.Client(conn, config)
+	if err := client.Handshake(); err != nil {
+		if requested {
+			return nil, requested, fmt.Errorf("TLS handshake failed (client certificate sent): %v", err)
+		}
+		return nil, requested, fmt.Errorf("TLS handshake failed: %v", err)
+	}
+	return client, requested, nil
+}
+
+// describe lists the negotiated parameters of an established connection,
+// running the --insecure verification and the --ocsp check on the way.
+func (p *tlsProber) describe(client *tls.Conn, requested bool, timeout time.Duration) (string, error) {
+	state := client.ConnectionState()
+	detail := tlsVersionName(state.Version) + " " + tls.CipherSuiteName(state.CipherSuite)
+	if len(p.config.NextProtos) > 0 {
+		if state.NegotiatedProtocol != "" {
+			detail += ", ALPN " + state.NegotiatedProtocol
+		} else {
+			detail += ", no ALPN protocol selected"
+		}
+	}
+	if p.config.InsecureSkipVerify {
+		if err := p.verify(state, state.ServerName); err != nil {
+			detail += ", certificate not verified: " + err.Error()
+		} else {
+			detail += ", certificate verified"
+		}
+	}
+	if p.ocsp {
+		status, err := checkOCSP(state, timeout)
+		if err != nil {
+			return "", err
+		}
+		detail += ", " + status
+	}
+	if p.cert != nil {
+		if requested {
+			detail += ", client certificate sent"
+		} else {
+			detail += ", client certificate not requested"
+		}
+	}
+	return detail, nil
+}
+
+// verify checks the peer certificate chain the same way the handshake would
+// have without --insecure.
+func (p *tlsProber) verify(state tls.ConnectionState, serverName string) error {
+	if len(state.PeerCertificates) == 0 {
+		return fmt.Errorf("no certificate presented")
+	}
+	opts := x509.VerifyOptions{
+		Roots:         p.roots,
+		DNSName:       serverName,
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, cert := range state.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	_, err := state.PeerCertificates[0].Verify(opts)
+	return err
+}
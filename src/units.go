@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// rttUnit and rttDecimals control how RTTs are printed, set by --unit and
+// --decimals. Measurements are kept at nanosecond precision regardless.
+var (
+	rttUnit     = time.Millisecond
+	rttSuffix   = "ms"
+	rttDecimals = 0
+)
+
+// setRTTFormat applies the --unit and --decimals flags.
+func setRTTFormat(unit string, decimals int) error {
+	switch unit {
+	case "us":
+		rttUnit, rttSuffix = time.Microsecond, "us"
+	case "ms":
+		rttUnit, rttSuffix = time.Millisecond, "ms"
+	case "s":
+		rttUnit, rttSuffix = time.Second, "s"
+	default:
+		return fmt.Errorf("--unit must be one of us, ms or s, got %q", unit)
+	}
+	if decimals < 0 || decimals > 9 {
+		return fmt.Errorf("--decimals must be between 0 and 9")
+	}
+	rttDecimals = decimals
+	return nil
+}
+
+// formatRTT formats d in the configured unit and precision, e.g. "12ms" or
+// "0.083ms".
+func formatRTT(d time.Duration) string {
+	return fmt.Sprintf("%.*f%s", rttDecimals, float64(d)/float64(rttUnit), rttSuffix)
+}
+
+// formatRTTDelta is formatRTT with an explicit sign.
+func formatRTTDelta(d time.Duration) string {
+	return fmt.Sprintf("%+.*f%s", rttDecimals, float64(d)/float64(rttUnit), rttSuffix)
+}
+
+// rttResolution is the smallest difference visible at the configured
+// precision, used as the bucket width for the mode.
+func rttResolution() time.Duration {
+	r := time.Duration(float64(rttUnit) / math.Pow10(rttDecimals))
+	if r < 1 {
+		r = 1
+	}
+	return r
+}
+
+// milliseconds converts d to fractional milliseconds for machine-readable
+// output.
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
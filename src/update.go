@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// version is the release this binary was built from, set at build time with
+// -ldflags "-X main.version=v1.2.3". Development builds are always older
+// than any release.
+var version = "dev"
+
+// maxBinarySize caps how much of a release asset is downloaded.
+const maxBinarySize = 64 << 20
+
+// release is the part of a GitHub release that self-update uses.
+type release struct {
+	TagName string         `json:"tag_name"`
+	Assets  []releaseAsset `json:"assets"`
+}
+
+type releaseAsset struct {
+	Name string `json:"name"`
+	URL  string `json:"browser_download_url"`
+}
+
+// runSelfUpdate implements `tcping self-update`: it looks up the latest
+// release, downloads the binary for this platform, verifies it against the
+// SHA-256 checksums published with the release and replaces the running
+// executable. With --check-only it only reports whether an update is
+// available, exiting with 2 if there is one so CI jobs can fail on outdated
+// copies.
+func runSelfUpdate(args []string) int {
+	flags := flag.NewFlagSet("self-update", flag.ExitOnError)
+	checkOnly := flags.Bool("check-only", false, "Only check whether a newer release is available")
+	force := flags.Bool("force", false, "Install the latest release even over a development build or the same version")
+	repo := flags.String("repo", "mouse0232/tcping", "GitHub repository the releases are taken from")
+	api := flags.String("api", "https://api.github.com", "GitHub API URL, e.g. of a GitHub Enterprise server")
+	timeout := flags.Duration("timeout", time.Minute, "Timeout for each request")
+	flags.Usage = func() {
+		fmt.Println("Usage: tcping self-update [--check-only] [--force] [--repo owner/name]")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	client := &http.Client{Timeout: *timeout}
+	latest, err := latestRelease(client, *api, *repo)
+	if err != nil {
+		fmt.Printf("Failed to check for updates: %v\n", err)
+		return 1
+	}
+	// Builds not made by the release workflow have no version to compare,
+	// they are only replaced when asked to.
+	if !*force && version == "dev" {
+		fmt.Printf("This is a development build of tcping, the latest release is %s. Use --force to install it.\n", latest.TagName)
+		return 0
+	}
+	if !*force && !newerVersion(latest.TagName, version) {
+		fmt.Printf("tcping %s is up to date.\n", version)
+		return 0
+	}
+	fmt.Printf("tcping %s is available, this is %s.\n", latest.TagName, version)
+	if *checkOnly {
+		return 2
+	}
+
+	if err := installRelease(client, latest); err != nil {
+		fmt.Printf("Failed to update: %v\n", err)
+		return 1
+	}
+	fmt.Printf("Updated to tcping %s.\n", latest.TagName)
+	return 0
+}
+
+func latestRelease(client *http.Client, api, repo string) (*release, error) {
+	req, err := http.NewRequest("GET", strings.TrimSuffix(api, "/")+"/repos/"+repo+"/releases/latest", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP %s from %s", resp.Status, req.URL)
+	}
+	var r release
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return nil, err
+	}
+	if r.TagName == "" {
+		return nil, fmt.Errorf("no release found for %s", repo)
+	}
+	return &r, nil
+}
+
+// installRelease downloads the binary for this platform from r, checks it and
+// swaps it in for the running executable.
+func installRelease(client *http.Client, r *release) error {
+	name := fmt.Sprintf("tcping-%s-%s", runtime.GOOS, runtime.GOARCH)
+	var binary, sums *releaseAsset
+	for i, a := range r.Assets {
+		switch {
+		case a.Name == name || a.Name == name+".exe":
+			binary = &r.Assets[i]
+		case a.Name == name+".sha256" || a.Name == "SHA256SUMS" || a.Name == "checksums.txt":
+			if sums == nil || strings.HasSuffix(a.Name, ".sha256") {
+				sums = &r.Assets[i]
+			}
+		}
+	}
+	if binary == nil {
+		return fmt.Errorf("release %s has no %s binary", r.TagName, name)
+	}
+	if sums == nil {
+		return fmt.Errorf("release %s publishes no SHA-256 checksums, refusing to install it", r.TagName)
+	}
+
+	sumFile, err := download(client, sums.URL, 1<<20)
+	if err != nil {
+		return err
+	}
+	want, err := findChecksum(sumFile, binary.Name)
+	if err != nil {
+		return err
+	}
+	content, err := download(client, binary.URL, maxBinarySize)
+	if err != nil {
+		return err
+	}
+	if sum := sha256.Sum256(content); hex.EncodeToString(sum[:]) != want {
+		return fmt.Errorf("checksum mismatch for %s: got %x, want %s", binary.Name, sum, want)
+	}
+	return replaceExecutable(content)
+}
+
+func download(client *http.Client, url string, limit int64) ([]byte, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP %s from %s", resp.Status, url)
+	}
+	content, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err == nil && int64(len(content)) > limit {
+		err = fmt.Errorf("%s is larger than %d bytes", url, limit)
+	}
+	return content, err
+}
+
+// findChecksum returns the SHA-256 checksum for name from a file in the
+// format of sha256sum, "<hex>  <name>" per line. A file with a single bare
+// checksum applies to name too.
+func findChecksum(sums []byte, name string) (string, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(sums))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 1 || (len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == name) {
+			if sum := strings.ToLower(fields[0]); len(sum) == sha256.Size*2 {
+				return sum, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("no checksum for %s", name)
+}
+
+// replaceExecutable writes content next to the running executable and moves
+// it into place. The old binary is renamed out of the way first, which also
+// works on Windows where a running executable cannot be overwritten but can
+// be renamed.
+func replaceExecutable(content []byte) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return err
+	}
+
+	tmp := exe + ".new"
+	if err := os.WriteFile(tmp, content, 0755); err != nil {
+		return err
+	}
+	old := exe + ".old"
+	os.Remove(old)
+	if err := os.Rename(exe, old); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, exe); err != nil {
+		os.Rename(old, exe)
+		os.Remove(tmp)
+		return err
+	}
+	// Fails on Windows while the old binary is still running; it is removed
+	// by the next update instead.
+	os.Remove(old)
+	return nil
+}
+
+// newerVersion reports whether the release tag latest is newer than current,
+// comparing the dot separated numbers of versions like v1.2.3.
+func newerVersion(latest, current string) bool {
+	a, b := versionNumbers(latest), versionNumbers(current)
+	for i := 0; i < len(a) || i < len(b); i++ {
+		var x, y int
+		if i < len(a) {
+			x = a[i]
+		}
+		if i < len(b) {
+			y = b[i]
+		}
+		if x != y {
+			return x > y
+		}
+	}
+	return false
+}
+
+func versionNumbers(v string) []int {
+	v = strings.TrimPrefix(strings.TrimSpace(v), "v")
+	if i := strings.IndexAny(v, "-+"); i >= 0 {
+		v = v[:i]
+	}
+	var numbers []int
+	for _, part := range strings.Split(v, ".") {
+		n, _ := strconv.Atoi(part)
+		numbers = append(numbers, n)
+	}
+	return numbers
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFindChecksum(t *testing.T) {
+	linux := strings.Repeat("a", 64)
+	windows := strings.Repeat("b", 64)
+	sums := linux + "  tcping-linux-amd64\n" +
+		windows + " *tcping-windows-amd64.exe\n" +
+		"abc  tcping-darwin-arm64\n"
+
+	tests := []struct {
+		sums, name, want string
+	}{
+		{sums, "tcping-linux-amd64", linux},
+		{sums, "tcping-windows-amd64.exe", windows},
+		{strings.ToUpper(linux) + "  tcping-linux-amd64\n", "tcping-linux-amd64", linux},
+		// A .sha256 file with just the checksum.
+		{linux + "\n", "tcping-linux-arm64", linux},
+		{sums, "tcping-linux-arm64", ""},
+		{sums, "tcping-linux-amd64.exe", ""},
+		// Checksums of the wrong length are not SHA-256.
+		{sums, "tcping-darwin-arm64", ""},
+		{"", "tcping-linux-amd64", ""},
+	}
+	for _, tt := range tests {
+		got, err := findChecksum([]byte(tt.sums), tt.name)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("findChecksum(%q, %q) = %q, want an error", tt.sums, tt.name, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("findChecksum(%q, %q) = %q, %v, want %q", tt.sums, tt.name, got, err, tt.want)
+		}
+	}
+}
+
+func TestNewerVersion(t *testing.T) {
+	tests := []struct {
+		latest, current string
+		want            bool
+	}{
+		{"v1.2.4", "v1.2.3", true},
+		{"v1.3.0", "v1.2.9", true},
+		{"v2.0.0", "v1.10.0", true},
+		{"v1.10.0", "v1.9.0", true},
+		{"v1.2.3", "v1.2.3", false},
+		{"v1.2.3", "v1.2.4", false},
+		{"v1.2", "v1.2.0", false},
+		{"v1.2.1", "v1.2", true},
+		{"1.2.4", "v1.2.3", true},
+		{"v1.2.3", "v1.2.3-rc1", false},
+		{"v1.2.4-rc1", "v1.2.3", true},
+		{"v1.2.3+build5", "v1.2.3", false},
+		{" v1.2.4 ", "v1.2.3", true},
+		{"v0.0.1", "dev", true},
+		{"v1.0.0", "", true},
+	}
+	for _, tt := range tests {
+		if got := newerVersion(tt.latest, tt.current); got != tt.want {
+			t.Errorf("newerVersion(%q, %q) = %v, want %v", tt.latest, tt.current, got, tt.want)
+		}
+	}
+}
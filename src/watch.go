@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// watchHistory is the number of recent results shown per target.
+const watchHistory = 10
+
+type watchResult struct {
+	line    string
+	elapsed time.Duration
+	err     error
+}
+
+// watchScreen replaces the scrolling output of --watch with a status screen
+// that is redrawn after every round.
+type watchScreen struct {
+	targets  []*target
+	interval time.Duration
+	history  map[*target][]watchResult
+}
+
+func newWatchScreen(targets []*target, interval time.Duration) *watchScreen {
+	return &watchScreen{
+		targets:  targets,
+		interval: interval,
+		history:  make(map[*target][]watchResult),
+	}
+}
+
+// result keeps the last watchHistory results of each target.
+func (w *watchScreen) result(t *target, line string, elapsed time.Duration, err error) {
+	h := append(w.history[t], watchResult{line: line, elapsed: elapsed, err: err})
+	if len(h) > watchHistory {
+		h = h[len(h)-watchHistory:]
+	}
+	w.history[t] = h
+}
+
+// draw clears the terminal and prints the status of every target.
+func (w *watchScreen) draw() {
+	var b strings.Builder
+	b.WriteString("\033[H\033[2J")
+	fmt.Fprintf(&b, "Every %s: tcping    %s\n", w.interval, time.Now().Format("2006-01-02 15:04:05"))
+
+	for _, t := range w.targets {
+		s := t.stats
+		b.WriteString("\n")
+		fmt.Fprintf(&b, "%s", t)
+		if t.hostname != "" {
+			fmt.Fprintf(&b, " (%s)", t.hostname)
+		}
+		b.WriteString("\n")
+
+		if s.sentCount > 0 {
+			fmt.Fprintf(&b, "  %d sent, %d responsed, %.2f%% loss", s.sentCount, s.respondedCount, s.loss())
+			if s.respondedCount > 0 {
+				fmt.Fprintf(&b, ", min/avg/max = %s/%s/%s", formatRTT(s.minTime), formatRTT(s.avg()), formatRTT(s.maxTime))
+			}
+			b.WriteString("\n")
+		}
+
+		var recent statistics
+		for _, r := range w.history[t] {
+			recent.add(r.elapsed, r.err)
+		}
+		if recent.sentCount > 0 {
+			fmt.Fprintf(&b, "  last %d: %.2f%% loss", recent.sentCount, recent.loss())
+			if recent.respondedCount > 0 {
+				fmt.Fprintf(&b, ", avg %s", formatRTT(recent.avg()))
+			}
+			b.WriteString("\n")
+		}
+
+		for _, r := range w.history[t] {
+			fmt.Fprintf(&b, "  %s\n", r.line)
+		}
+	}
+	fmt.Print(b.String())
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// wakeOnLANPort is where magic packets are sent unless --wol gives a port.
+const wakeOnLANPort = "9"
+
+// wakeOnLAN is a parsed --wol value.
+type wakeOnLAN struct {
+	mac       net.HardwareAddr
+	broadcast string
+}
+
+// parseWakeOnLAN parses a --wol value of the form MAC[,broadcast[:port]],
+// e.g. 00:11:22:33:44:55,192.168.1.255. The broadcast address defaults to
+// 255.255.255.255.
+func parseWakeOnLAN(s string) (*wakeOnLAN, error) {
+	parts := strings.SplitN(s, ",", 2)
+	mac, err := net.ParseMAC(strings.TrimSpace(parts[0]))
+	if err != nil || len(mac) != 6 {
+		return nil, fmt.Errorf("invalid MAC address %q in --wol", parts[0])
+	}
+
+	broadcast := "255.255.255.255"
+	if len(parts) == 2 {
+		broadcast = strings.TrimSpace(parts[1])
+	}
+	if _, _, err := net.SplitHostPort(broadcast); err != nil {
+		broadcast = net.JoinHostPort(strings.Trim(broadcast, "[]"), wakeOnLANPort)
+	}
+	return &wakeOnLAN{mac: mac, broadcast: broadcast}, nil
+}
+
+// send broadcasts the magic packet: six 0xff bytes followed by the MAC
+// address repeated 16 times.
+func (w *wakeOnLAN) send() error {
+	packet := append(bytes.Repeat([]byte{0xff}, 6), bytes.Repeat(w.mac, 16)...)
+	conn, err := net.Dial("udp", w.broadcast)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write(packet)
+	return err
+}
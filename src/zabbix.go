@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+const zabbixDefaultPort = "10051"
+
+const zabbixTimeout = 5 * time.Second
+
+// zabbixMaxReply caps the length a response header may claim. The server
+// only answers with a short JSON status, anything longer is not Zabbix.
+const zabbixMaxReply = 1 << 20
+
+// zabbixSender ships item values to a Zabbix server or proxy using the
+// sender protocol, the same one zabbix_sender speaks. Values are queued and
+// sent in the background, so a slow or unreachable server does not hold up
+// the pings; whatever queued up while a request was in flight goes out
+// together in the next one.
+type zabbixSender struct {
+	server string
+	host   string
+
+	// keyed adds the target as key parameters, e.g. tcping.rtt[1.1.1.1,80],
+	// so that several targets can be told apart on the same host.
+	keyed bool
+
+	queue chan []zabbixItem
+	done  chan struct{}
+}
+
+type zabbixItem struct {
+	Host  string `json:"host"`
+	Key   string `json:"key"`
+	Value string `json:"value"`
+	Clock int64  `json:"clock"`
+}
+
+type zabbixRequest struct {
+	Request string       `json:"request"`
+	Data    []zabbixItem `json:"data"`
+}
+
+type zabbixResponse struct {
+	Response string `json:"response"`
+	Info     string `json:"info"`
+}
+
+func newZabbixSender(server, host string, keyed bool) (*zabbixSender, error) {
+	if host == "" {
+		return nil, fmt.Errorf("--zabbix-host is required when --zabbix is set")
+	}
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(strings.Trim(server, "[]"), zabbixDefaultPort)
+	}
+	z := &zabbixSender{
+		server: server,
+		host:   host,
+		keyed:  keyed,
+		queue:  make(chan []zabbixItem, 1000),
+		done:   make(chan struct{}),
+	}
+	go z.deliver()
+	return z, nil
+}
+
+// sendProbe queues the result of a single probe along with the loss seen so
+// far for its target. Failed probes only report loss since there is no RTT to
+// send.
+func (z *zabbixSender) sendProbe(t *target, elapsed time.Duration, probeErr error) {
+	now := time.Now().Unix()
+	items := []zabbixItem{{
+		Host:  z.host,
+		Key:   z.key("tcping.loss", t),
+		Value: fmt.Sprintf("%.2f", t.stats.loss()),
+		Clock: now,
+	}}
+	if probeErr == nil {
+		items = append(items, zabbixItem{
+			Host:  z.host,
+			Key:   z.key("tcping.rtt", t),
+			Value: fmt.Sprintf("%.3f", milliseconds(elapsed)),
+			Clock: now,
+		})
+	}
+	select {
+	case z.queue <- items:
+	default:
+		// The server is too slow or unreachable; deliver reports the
+		// failures already.
+	}
+}
+
+// key names the item of t after the host as given, which stays the same
+// when --time-dns or --fallback-family change the address. The two targets
+// of --compare-family add their address family.
+func (z *zabbixSender) key(name string, t *target) string {
+	if !z.keyed {
+		return name
+	}
+	if t.perFamily {
+		return fmt.Sprintf("%s[%s,%s,%s]", name, strings.Trim(t.host, "[]"), t.port, t.version)
+	}
+	return fmt.Sprintf("%s[%s,%s]", name, strings.Trim(t.host, "[]"), t.port)
+}
+
+// deliver sends the queued values, reporting failures once until a request
+// succeeds again.
+func (z *zabbixSender) deliver() {
+	defer close(z.done)
+	failing := false
+	for items := range z.queue {
+	more:
+		for {
+			select {
+			case next, ok := <-z.queue:
+				if !ok {
+					break more
+				}
+				items = append(items, next...)
+			default:
+				break more
+			}
+		}
+		err := z.send(items)
+		if err != nil && !failing {
+			fmt.Printf("Failed to send results to Zabbix: %v\n", err)
+		} else if err == nil && failing {
+			fmt.Printf("Sending results to Zabbix at %s again\n", z.server)
+		}
+		failing = err != nil
+	}
+}
+
+// close sends the values still queued and waits for them a little while.
+func (z *zabbixSender) close() {
+	close(z.queue)
+	select {
+	case <-z.done:
+	case <-time.After(2 * zabbixTimeout):
+		fmt.Println("Gave up waiting for results to be sent to Zabbix")
+	}
+}
+
+func (z *zabbixSender) send(items []zabbixItem) error {
+	body, err := json.Marshal(zabbixRequest{Request: "sender data", Data: items})
+	if err != nil {
+		return err
+	}
+
+	conn, err := net.DialTimeout("tcp", z.server, zabbixTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(zabbixTimeout))
+
+	// Header is "ZBXD", protocol flags and a little-endian 64-bit length.
+	packet := make([]byte, 13, 13+len(body))
+	copy(packet, "ZBXD\x01")
+	binary.LittleEndian.PutUint64(packet[5:], uint64(len(body)))
+	packet = append(packet, body...)
+	if _, err := conn.Write(packet); err != nil {
+		return err
+	}
+
+	header := make([]byte, 13)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return err
+	}
+	if string(header[:4]) != "ZBXD" {
+		return fmt.Errorf("invalid response header from %s", z.server)
+	}
+	length := binary.LittleEndian.Uint64(header[5:])
+	if length > zabbixMaxReply {
+		return fmt.Errorf("response of %d bytes from %s is too long", length, z.server)
+	}
+	reply := make([]byte, length)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+
+	var resp zabbixResponse
+	if err := json.Unmarshal(reply, &resp); err != nil {
+		return err
+	}
+	if resp.Response != "success" {
+		return fmt.Errorf("zabbix server rejected data: %s", resp.Info)
+	}
+	return nil
+}
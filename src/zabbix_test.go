@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+)
+
+func TestZabbixKey(t *testing.T) {
+	tests := []struct {
+		keyed bool
+		t     *target
+		want  string
+	}{
+		{false, &target{host: "example.com", address: "192.0.2.1", port: "80"}, "tcping.rtt"},
+		{true, &target{host: "example.com", address: "192.0.2.1", port: "80"}, "tcping.rtt[example.com,80]"},
+		{true, &target{host: "[2001:db8::1]", address: "[2001:db8::1]", port: "443"}, "tcping.rtt[2001:db8::1,443]"},
+		{true, &target{host: "example.com", version: "ipv4", perFamily: true, address: "192.0.2.1", port: "80"}, "tcping.rtt[example.com,80,ipv4]"},
+		{true, &target{host: "example.com", version: "ipv6", perFamily: true, address: "[2001:db8::1]", port: "80"}, "tcping.rtt[example.com,80,ipv6]"},
+	}
+	for _, tt := range tests {
+		z := &zabbixSender{keyed: tt.keyed}
+		if got := z.key("tcping.rtt", tt.t); got != tt.want {
+			t.Errorf("key(%+v) = %q, want %q", *tt.t, got, tt.want)
+		}
+	}
+}
+
+func TestZabbixSendReply(t *testing.T) {
+	tests := []struct {
+		name  string
+		reply []byte
+		fails bool
+	}{
+		{"success", zabbixReply(`{"response":"success","info":"processed: 1"}`), false},
+		{"rejected", zabbixReply(`{"response":"failed","info":"processed: 0"}`), true},
+		{"not zabbix", []byte("HTTP/1.1 400 Bad Request\r\n\r\n"), true},
+		{"huge length", append([]byte("ZBXD\x01"), 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff), true},
+		{"too long", append([]byte("ZBXD\x01"), 0x01, 0x00, 0x10, 0x00, 0x00, 0x00, 0x00, 0x00), true},
+	}
+	for _, tt := range tests {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		go func(reply []byte) {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			io.ReadFull(conn, make([]byte, 13))
+			conn.Write(reply)
+		}(tt.reply)
+
+		z := &zabbixSender{server: ln.Addr().String(), host: "h"}
+		err = z.send([]zabbixItem{{Host: "h", Key: "tcping.rtt", Value: "1.000"}})
+		ln.Close()
+		if (err != nil) != tt.fails {
+			t.Errorf("%s: send() error = %v, want failure %v", tt.name, err, tt.fails)
+		}
+	}
+}
+
+func zabbixReply(body string) []byte {
+	reply := make([]byte, 13, 13+len(body))
+	copy(reply, "ZBXD\x01")
+	binary.LittleEndian.PutUint64(reply[5:], uint64(len(body)))
+	return append(reply, body...)
+}